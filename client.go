@@ -11,21 +11,28 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cheggaaa/pb/v3"
+	"github.com/go-openapi/runtime"
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
 	"github.com/jkawamoto/go-civitai/client"
 	"github.com/jkawamoto/go-civitai/client/operations"
 	"github.com/jkawamoto/go-civitai/models"
 	"github.com/zeebo/blake3"
 	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -33,11 +40,31 @@ var (
 	ErrFileHashNotMatch = errors.New("file hash doesn't match")
 	ErrGetFailure       = errors.New("failed to get a file")
 	ErrNoFilename       = errors.New("failed to get a filename")
+	ErrModelNotFound    = errors.New("model information is not found")
+	ErrAuthRequired     = errors.New("authentication is required; give a Civitai API token with -token, CIVITAI_API_TOKEN, or the config file")
+	ErrAuthFailed       = errors.New("the Civitai API token is rejected or has no access to this model")
+	ErrInvalidAPIURL    = errors.New("invalid API URL")
 )
 
+// isNotFound returns true if the given error is a 404 error returned from Civitai or wraps ErrModelNotFound.
+func isNotFound(err error) bool {
+	var coder interface {
+		Code() int
+	}
+	return errors.As(err, &coder) && coder.Code() == http.StatusNotFound || errors.Is(err, ErrModelNotFound)
+}
+
 type Client struct {
 	clientService operations.ClientService
 	httpClient    *http.Client
+	token         string
+	// apiURL is the base URL of the Civitai API used for endpoints the generated client doesn't cover.
+	apiURL string
+	// limiter limits the rate of requests to the Civitai API. It is shared by copies of the client.
+	limiter *rate.Limiter
+	// cacheDir is the directory to cache responses of the Civitai API in for cacheTTL.
+	cacheDir string
+	cacheTTL time.Duration
 
 	PreferredFormat string
 }
@@ -45,23 +72,117 @@ type Client struct {
 func NewClient(preferredFormat string) Client {
 	return Client{
 		clientService:   client.Default.Operations,
+		apiURL:          client.DefaultSchemes[0] + "://" + client.DefaultHost + client.DefaultBasePath,
 		PreferredFormat: preferredFormat,
 	}
 }
 
-func (cli Client) GetModelVersion(ctx context.Context, hash string) (*models.ModelVersion, error) {
-	res, err := cli.clientService.GetModelVersionByHash(
-		operations.NewGetModelVersionByHashParamsWithContext(ctx).WithHTTPClient(cli.httpClient).WithHash(hash))
+// WithToken returns a copy of the client that authenticates requests with the given Civitai API token.
+func (cli Client) WithToken(token string) Client {
+	cli.token = token
+	return cli
+}
+
+// WithHTTPClient returns a copy of the client that sends requests with the given HTTP client to the Civitai API
+// at the given base URL, such as https://civitai.com/api/v1. If the URL is empty, the default one is used.
+func (cli Client) WithHTTPClient(httpClient *http.Client, apiURL string) (Client, error) {
+	cli.httpClient = httpClient
+	if apiURL == "" {
+		return cli, nil
+	}
+
+	u, err := url.Parse(apiURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return cli, fmt.Errorf("%w: %v", ErrInvalidAPIURL, apiURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	cli.clientService = client.New(
+		httptransport.NewWithClient(u.Host, u.Path, []string{u.Scheme}, httpClient), strfmt.Default).Operations
+	cli.apiURL = u.String()
+	return cli, nil
+}
+
+// WithRateLimit returns a copy of the client that sends at most the given number of requests per second to the
+// Civitai API. Zero or less means no limit. Downloads are not limited.
+func (cli Client) WithRateLimit(rps float64) Client {
+	cli.limiter = nil
+	if rps > 0 {
+		cli.limiter = rate.NewLimiter(rate.Limit(rps), max(1, int(rps)))
+	}
+	return cli
+}
+
+// WithCache returns a copy of the client that caches responses of the Civitai API in the given directory.
+// A cached response is used without asking the server for the given TTL, and after that, it is used again if the
+// server tells it is not modified. Zero or less TTL disables the cache.
+func (cli Client) WithCache(dir string, ttl time.Duration) Client {
+	cli.cacheDir = dir
+	cli.cacheTTL = ttl
+	return cli
+}
+
+// apiHTTPClient returns the HTTP client sending requests to the Civitai API through the rate limiter and the cache.
+func (cli Client) apiHTTPClient() *http.Client {
+	if cli.limiter == nil && (cli.cacheDir == "" || cli.cacheTTL <= 0) {
+		return cli.httpClient
+	}
+
+	var res http.Client
+	if cli.httpClient != nil {
+		res = *cli.httpClient
+	}
+	tr := res.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	if cli.limiter != nil {
+		tr = rateLimitTransport{limiter: cli.limiter, base: tr}
+	}
+	if cli.cacheDir != "" && cli.cacheTTL > 0 {
+		// cached responses don't consume the rate limit.
+		tr = responseCache{dir: cli.cacheDir, ttl: cli.cacheTTL, base: tr}
+	}
+	res.Transport = tr
+	return &res
+}
+
+// options returns client options that authenticate requests to the Civitai API.
+func (cli Client) options() []operations.ClientOption {
+	if cli.token == "" {
+		return nil
+	}
+	return []operations.ClientOption{
+		func(op *runtime.ClientOperation) {
+			op.AuthInfo = httptransport.BearerToken(cli.token)
+		},
+	}
+}
+
+// GetModelVersion returns the model version that has a file with the given hash and the ID of the model it belongs to.
+// The response is decoded here since the generated client drops the model ID.
+func (cli Client) GetModelVersion(ctx context.Context, hash string) (*models.ModelVersion, int64, error) {
+	data, err := cli.getAPI(ctx, "/model-versions/by-hash/"+url.PathEscape(hash), "hash "+hash)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return res.GetPayload(), nil
+	var ver models.ModelVersion
+	if err = json.Unmarshal(data, &ver); err != nil {
+		return nil, 0, err
+	}
+	var ref struct {
+		ModelID int64 `json:"modelId"`
+	}
+	if err = json.Unmarshal(data, &ref); err != nil {
+		return nil, 0, err
+	}
+	return &ver, ref.ModelID, nil
 }
 
 func (cli Client) GetModel(ctx context.Context, id int64) (*models.Model, error) {
 	res, err := cli.clientService.GetModel(
-		operations.NewGetModelParamsWithContext(ctx).WithHTTPClient(cli.httpClient).WithModelID(id))
+		operations.NewGetModelParamsWithContext(ctx).WithHTTPClient(cli.apiHTTPClient()).WithModelID(id),
+		cli.options()...)
 	if err != nil {
 		return nil, err
 	}
@@ -69,8 +190,53 @@ func (cli Client) GetModel(ctx context.Context, id int64) (*models.Model, error)
 	return res.GetPayload(), nil
 }
 
-// Download gets a model file associated with the given version and stores it into the given directory.
-func (cli Client) Download(ctx context.Context, ver *models.ModelVersion, dir string) (err error) {
+// GetModelIDByVersion returns the ID of the model the given version belongs to.
+func (cli Client) GetModelIDByVersion(ctx context.Context, versionID int64) (int64, error) {
+	data, err := cli.getAPI(ctx, fmt.Sprintf("/model-versions/%d", versionID), fmt.Sprintf("version %v", versionID))
+	if err != nil {
+		return 0, err
+	}
+
+	var ver struct {
+		ModelID int64 `json:"modelId"`
+	}
+	if err = json.Unmarshal(data, &ver); err != nil {
+		return 0, err
+	}
+	return ver.ModelID, nil
+}
+
+// getAPI sends a GET request to the given path of the Civitai API and returns the response body.
+// If the server responds 404, it returns an error wrapping ErrModelNotFound with the given description of the item.
+func (cli Client) getAPI(ctx context.Context, p, item string) (_ []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cli.apiURL+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cli.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.token)
+	}
+
+	res, err := ctxhttp.Do(ctx, cli.apiHTTPClient(), req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, res.Body.Close())
+	}()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %v", ErrModelNotFound, item)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrGetFailure, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// selectFile returns the file of the given version to download, which is a file in the preferred format
+// or the primary file. It returns nil if the version has no such files.
+func (cli Client) selectFile(ver *models.ModelVersion) *models.File {
 	var file *models.File
 	for _, f := range ver.Files {
 		if strings.ToLower(f.Format) == cli.PreferredFormat {
@@ -80,13 +246,49 @@ func (cli Client) Download(ctx context.Context, ver *models.ModelVersion, dir st
 			file = f
 		}
 	}
+	return file
+}
+
+// partFileExt is the extension of files being downloaded.
+const partFileExt = ".part"
+
+// Download gets a model file associated with the given version, stores it into the given directory,
+// and returns the path to the stored file. Use WriteMetadata to write the preview image and the information of the
+// version next to the file.
+//
+// The file is downloaded into a partial file named after the file name with the ".part" extension in the same
+// directory, and it is synced and renamed after its hash is verified, so that a failed download never leaves a broken
+// model. If the download fails or is canceled, the partial file is kept to resume the download later only if the server
+// supports range requests; otherwise, it is removed.
+func (cli Client) Download(ctx context.Context, ver *models.ModelVersion, dir string) (_ string, err error) {
+	file := cli.selectFile(ver)
 	if file == nil {
-		return ErrFileNotFound
+		return "", ErrFileNotFound
+	}
+
+	var part string
+	var offset int64
+	if file.Name != "" {
+		part = filepath.Join(dir, file.Name+partFileExt)
+		if info, err := os.Stat(part); err == nil {
+			offset = info.Size()
+		}
 	}
 
-	res, err := ctxhttp.Get(ctx, cli.httpClient, file.DownloadURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.DownloadURL, nil)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if offset != 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if cli.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.token)
+	}
+
+	res, err := ctxhttp.Do(ctx, cli.httpClient, req)
+	if err != nil {
+		return "", err
 	}
 	defer func() {
 		if _, e := io.Copy(io.Discard, res.Body); e != nil {
@@ -94,41 +296,86 @@ func (cli Client) Download(ctx context.Context, ver *models.ModelVersion, dir st
 		}
 		err = errors.Join(err, res.Body.Close())
 	}()
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("%w: %v", ErrGetFailure, res.Status)
+	switch res.StatusCode {
+	case http.StatusOK:
+		// the server doesn't support range requests, so download the whole file again.
+		offset = 0
+	case http.StatusPartialContent:
+		var start int64
+		if _, err = fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return "", fmt.Errorf("%w: unexpected content range %q", ErrGetFailure, res.Header.Get("Content-Range"))
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file usually has the whole contents already, so use it if its hash matches.
+		if hash, err := fileHash(part); err == nil && hash == strings.ToLower(file.Hashes.BLAKE3) {
+			return complete(part, filepath.Join(dir, file.Name))
+		}
+		// otherwise, the partial file is broken, so remove it and start over.
+		if err = os.Remove(part); err != nil {
+			return "", err
+		}
+		return cli.Download(ctx, ver, dir)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if cli.token == "" {
+			return "", fmt.Errorf("%w: %v", ErrAuthRequired, res.Status)
+		}
+		return "", fmt.Errorf("%w: %v", ErrAuthFailed, res.Status)
+	default:
+		return "", fmt.Errorf("%w: %v", ErrGetFailure, res.Status)
 	}
 
 	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
 	if err != nil {
-		return errors.Join(ErrNoFilename, err)
+		return "", errors.Join(ErrNoFilename, err)
 	}
 	name := params["filename"]
 
-	bar := pb.New(int(file.SizeKB * 1024))
+	dest := filepath.Join(dir, name)
+	if _, err = os.Stat(dest); err == nil {
+		return "", fmt.Errorf("%v already exists: %w", dest, os.ErrExist)
+	}
+	if part == "" {
+		part = dest + partFileExt
+	}
+
+	bar := pb.New64(int64(file.SizeKB * 1024))
 	bar.Set(pb.SIBytesPrefix, true)
 	bar.Set("prefix", filepath.Base(name)+" ")
+	bar.SetCurrent(offset)
 	bar.Start()
 	defer bar.Finish()
 
 	hash := blake3.New()
-	dest := filepath.Join(dir, name)
-	err = writeFile(dest, io.TeeReader(bar.NewProxyReader(res.Body), hash))
+	err = writePartFile(part, offset, hash, bar.NewProxyReader(res.Body))
 	if err != nil {
-		return err
+		if res.StatusCode != http.StatusPartialContent && res.Header.Get("Accept-Ranges") != "bytes" {
+			// the partial file cannot be resumed.
+			return "", errors.Join(err, os.Remove(part))
+		}
+		return "", err
 	}
 	if hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(file.Hashes.BLAKE3) {
 		// if hash doesn't match, remove the downloaded file.
-		return errors.Join(ErrFileHashNotMatch, os.Remove(dest))
+		return "", errors.Join(ErrFileHashNotMatch, os.Remove(part))
 	}
-	return nil
+	return complete(part, dest)
 }
 
-func writeFile(name string, r io.Reader) (err error) {
-	if _, err = os.Stat(name); err == nil {
-		return fmt.Errorf("%v already exists: %w", name, os.ErrExist)
+// complete renames the verified partial file to the given destination, and returns the destination.
+func complete(part, dest string) (string, error) {
+	if _, err := os.Stat(dest); err == nil {
+		return "", fmt.Errorf("%v already exists: %w", dest, os.ErrExist)
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return "", err
 	}
+	return dest, nil
+}
 
-	f, err := os.Create(name)
+// writePartFile writes data read from the given reader into the named file at the given offset,
+// and writes the whole contents of the resulting file into the given hash. The file is synced before it is closed.
+func writePartFile(name string, offset int64, hash io.Writer, r io.Reader) (err error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
@@ -136,6 +383,15 @@ func writeFile(name string, r io.Reader) (err error) {
 		err = errors.Join(err, f.Close())
 	}()
 
-	_, err = io.Copy(f, r)
-	return err
+	if _, err = io.CopyN(hash, f, offset); err != nil {
+		return err
+	}
+	if err = f.Truncate(offset); err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(f, io.TeeReader(r, hash)); err != nil {
+		return err
+	}
+	return f.Sync()
 }
@@ -11,6 +11,7 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -19,10 +20,14 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/zeebo/blake3"
 
+	"github.com/go-openapi/runtime"
+	"github.com/jkawamoto/go-civitai/client/operations"
 	"github.com/jkawamoto/go-civitai/models"
 )
 
@@ -56,6 +61,73 @@ func joinURL(t *testing.T, base string, path ...string) string {
 	return res
 }
 
+// notFoundError is an error returned from fakeService if the requested item doesn't exist.
+type notFoundError struct{}
+
+func (notFoundError) Code() int {
+	return http.StatusNotFound
+}
+
+func (notFoundError) Error() string {
+	return "not found"
+}
+
+// fakeService is an operations.ClientService that serves the given models.
+// Use newClient to also serve the endpoints the client requests without the service.
+type fakeService struct {
+	operations.ClientService
+	models []*models.Model
+}
+
+func (s *fakeService) GetModel(params *operations.GetModelParams, _ ...operations.ClientOption) (*operations.GetModelOK, error) {
+	for _, m := range s.models {
+		if m.ID == params.ModelID {
+			return &operations.GetModelOK{Payload: m}, nil
+		}
+	}
+	return nil, notFoundError{}
+}
+
+// newClient returns a client using the service and a stand-in of the Civitai API serving /model-versions/{id} and
+// /model-versions/by-hash/{hash}, which the service doesn't cover. Like the Civitai API, the version has its own ID
+// and the ID of the model as modelId.
+func (s *fakeService) newClient(t *testing.T) Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		hash, byHash := strings.CutPrefix(req.URL.Path, "/model-versions/by-hash/")
+		for _, m := range s.models {
+			for _, v := range m.ModelVersions {
+				match := req.URL.Path == fmt.Sprintf("/model-versions/%d", v.ID)
+				if byHash {
+					_, f := findVersionFile([]*models.ModelVersion{v}, hash)
+					match = f != nil
+				}
+				if !match {
+					continue
+				}
+				data, err := json.Marshal(struct {
+					*models.ModelVersion
+					ModelID int64 `json:"modelId"`
+				}{v, m.ID})
+				if err != nil {
+					t.Error(err)
+				}
+				res.Header().Set("Content-Type", "application/json")
+				_, _ = res.Write(data)
+				return
+			}
+		}
+		res.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	cli := NewClient(SafetensorFormat)
+	cli.clientService = s
+	cli.apiURL = server.URL
+	return cli
+}
+
 func TestNewClient(t *testing.T) {
 	format := "test"
 
@@ -68,6 +140,147 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestClient_WithToken(t *testing.T) {
+	cli := NewClient(SafetensorFormat)
+	if opts := cli.options(); len(opts) != 0 {
+		t.Errorf("expect no options, got %v", opts)
+	}
+
+	cli = cli.WithToken("token")
+	opts := cli.options()
+	if len(opts) != 1 {
+		t.Fatalf("expect 1 option, got %v", len(opts))
+	}
+	var op runtime.ClientOperation
+	opts[0](&op)
+	if op.AuthInfo == nil {
+		t.Error("expect an auth info writer")
+	}
+}
+
+func TestClient_WithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/model-versions/5" || req.UserAgent() != "test" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := res.Write([]byte(`{"id": 5, "modelId": 3}`)); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	httpClient, err := newHTTPClient(HTTPOptions{UserAgent: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := NewClient(SafetensorFormat).WithHTTPClient(httpClient, server.URL+"/api/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := cli.GetModelIDByVersion(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 {
+		t.Errorf("expect 3, got %v", id)
+	}
+
+	for _, u := range []string{"civitai.com/api/v1", "ftp://civitai.com/api/v1"} {
+		if _, err = NewClient(SafetensorFormat).WithHTTPClient(httpClient, u); !errors.Is(err, ErrInvalidAPIURL) {
+			t.Errorf("expect %v for %v, got %v", ErrInvalidAPIURL, u, err)
+		}
+	}
+}
+
+func TestClient_WithCache(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		if _, err := res.Write([]byte(`{"id": 5, "modelId": 3}`)); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cli, err := NewClient(SafetensorFormat).WithRateLimit(100).WithCache(t.TempDir(), time.Hour).
+		WithHTTPClient(nil, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		id, err := cli.GetModelIDByVersion(context.Background(), 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != 3 {
+			t.Errorf("expect 3, got %v", id)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expect 1 request, got %v", requests)
+	}
+
+	requests = 0
+	cli = cli.WithCache("", 0)
+	for range 2 {
+		if _, err = cli.GetModelIDByVersion(context.Background(), 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expect 2 requests without the cache, got %v", requests)
+	}
+}
+
+func TestClient_Download_auth(t *testing.T) {
+	target := "LICENSE"
+	token := "secret"
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer "+token {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v;", target))
+		http.ServeFile(res, req, target)
+	}))
+	t.Cleanup(server.Close)
+
+	ver := &models.ModelVersion{
+		Files: []*models.File{
+			{
+				DownloadURL: server.URL,
+				Format:      "SafeTensor",
+				Hashes: &models.Hash{
+					BLAKE3: modelHash(t, target),
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "no token", err: ErrAuthRequired},
+		{name: "wrong token", token: "wrong", err: ErrAuthFailed},
+		{name: "valid token", token: token},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cli := NewClient(SafetensorFormat).WithToken(c.token)
+			cli.httpClient = server.Client()
+
+			_, err := cli.Download(context.Background(), ver, t.TempDir())
+			if (c.err == nil && err != nil) || (c.err != nil && !errors.Is(err, c.err)) {
+				t.Errorf("expect %v, got %v", c.err, err)
+			}
+		})
+	}
+}
+
 func TestClient_Download(t *testing.T) {
 	ctx := context.Background()
 	target := "LICENSE"
@@ -92,9 +305,21 @@ func TestClient_Download(t *testing.T) {
 			t.Fatal()
 		}
 	})
+	mux.HandleFunc("/resumable", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v;", target))
+		http.ServeFile(res, req, target)
+	})
 	mux.HandleFunc("/no-content-disposition", func(res http.ResponseWriter, req *http.Request) {
 		res.WriteHeader(http.StatusOK)
 	})
+	mux.HandleFunc("/completed", func(res http.ResponseWriter, req *http.Request) {
+		// the partial file has the whole contents, so only a range request is expected.
+		if req.Header.Get("Range") == "" {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	})
 
 	server := httptest.NewServer(mux)
 	t.Cleanup(func() {
@@ -276,6 +501,106 @@ func TestClient_Download(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:            "resume a partial file",
+			preferredFormat: SafetensorFormat,
+			ver: &models.ModelVersion{
+				Files: []*models.File{
+					{
+						Name:        target,
+						DownloadURL: joinURL(t, server.URL, "resumable"),
+						Format:      "SafeTensor",
+						Hashes: &models.Hash{
+							BLAKE3: hash,
+						},
+					},
+				},
+			},
+			setup: func(t *testing.T, dir string) {
+				t.Helper()
+				data, err := os.ReadFile(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(filepath.Join(dir, target+partFileExt), data[:len(data)/2], 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:            "restart if the server doesn't support range requests",
+			preferredFormat: SafetensorFormat,
+			ver: &models.ModelVersion{
+				Files: []*models.File{
+					{
+						Name:        target,
+						DownloadURL: joinURL(t, server.URL, target),
+						Format:      "SafeTensor",
+						Hashes: &models.Hash{
+							BLAKE3: hash,
+						},
+					},
+				},
+			},
+			setup: func(t *testing.T, dir string) {
+				t.Helper()
+				if err := os.WriteFile(filepath.Join(dir, target+partFileExt), []byte("broken"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:            "restart if the partial file is larger than the file",
+			preferredFormat: SafetensorFormat,
+			ver: &models.ModelVersion{
+				Files: []*models.File{
+					{
+						Name:        target,
+						DownloadURL: joinURL(t, server.URL, "resumable"),
+						Format:      "SafeTensor",
+						Hashes: &models.Hash{
+							BLAKE3: hash,
+						},
+					},
+				},
+			},
+			setup: func(t *testing.T, dir string) {
+				t.Helper()
+				data, err := os.ReadFile(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(filepath.Join(dir, target+partFileExt), append(data, data...), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:            "use the partial file if it is complete",
+			preferredFormat: SafetensorFormat,
+			ver: &models.ModelVersion{
+				Files: []*models.File{
+					{
+						Name:        target,
+						DownloadURL: joinURL(t, server.URL, "completed"),
+						Format:      "SafeTensor",
+						Hashes: &models.Hash{
+							BLAKE3: hash,
+						},
+					},
+				},
+			},
+			setup: func(t *testing.T, dir string) {
+				t.Helper()
+				data, err := os.ReadFile(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(filepath.Join(dir, target+partFileExt), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -287,16 +612,99 @@ func TestClient_Download(t *testing.T) {
 			cli := NewClient(c.preferredFormat)
 			cli.httpClient = server.Client()
 
-			err := cli.Download(ctx, c.ver, dir)
+			res, err := cli.Download(ctx, c.ver, dir)
 			if (c.err == nil && err != nil) || (c.err != nil && !errors.Is(err, c.err)) {
 				t.Errorf("expect %v, got %v", c.err, err)
 			}
 
 			if c.err == nil {
+				if expect := filepath.Join(dir, target); res != expect {
+					t.Errorf("expect %v, got %v", expect, res)
+				}
 				h := modelHash(t, filepath.Join(dir, target))
 				if h != hash {
 					t.Errorf("expect %v, got %v", hash, h)
 				}
+				if _, err = os.Stat(filepath.Join(dir, target+partFileExt)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expect the partial file is removed, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_Download_canceled(t *testing.T) {
+	target := "LICENSE"
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		resumable bool
+	}{
+		{name: "not resumable"},
+		{name: "resumable", resumable: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v;", target))
+				res.Header().Set("Content-Length", fmt.Sprint(len(data)))
+				if c.resumable {
+					res.Header().Set("Accept-Ranges", "bytes")
+				}
+				res.WriteHeader(http.StatusOK)
+				if _, err := res.Write(data[:len(data)/2]); err != nil {
+					t.Error(err)
+				}
+				res.(http.Flusher).Flush()
+				<-req.Context().Done()
+			}))
+			t.Cleanup(server.Close)
+
+			cli := NewClient(SafetensorFormat)
+			cli.httpClient = server.Client()
+
+			// cancel the download once a part of the file is written.
+			dir := t.TempDir()
+			go func() {
+				for ctx.Err() == nil {
+					if info, err := os.Stat(filepath.Join(dir, target+partFileExt)); err == nil && info.Size() != 0 {
+						cancel()
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+
+			_, err := cli.Download(ctx, &models.ModelVersion{
+				Files: []*models.File{
+					{
+						Name:        target,
+						DownloadURL: server.URL,
+						Format:      "SafeTensor",
+						Hashes: &models.Hash{
+							BLAKE3: modelHash(t, target),
+						},
+					},
+				},
+			}, dir)
+			if err == nil {
+				t.Fatal("expect an error")
+			}
+
+			if _, err = os.Stat(filepath.Join(dir, target)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expect no model file, got %v", err)
+			}
+			_, err = os.Stat(filepath.Join(dir, target+partFileExt))
+			if c.resumable && err != nil {
+				t.Errorf("expect the partial file is kept, got %v", err)
+			} else if !c.resumable && !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expect the partial file is removed, got %v", err)
 			}
 		})
 	}
@@ -0,0 +1,214 @@
+// config.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the name of the config file in the root directory of the web UI.
+const configFile = "sd-model-updater.yaml"
+
+// Config is the content of a config file.
+// Command-line flags override values in it.
+type Config struct {
+	// Targets is a list of files and directories to check for updates.
+	// Relative paths are relative to the current directory.
+	Targets    []TargetConfig `yaml:"targets"`
+	Layout     string         `yaml:"layout"`
+	Format     string         `yaml:"format"`
+	Exclude    []string       `yaml:"exclude"`
+	Policy     Policy         `yaml:"policy"`
+	Old        OldVersionMode `yaml:"old"`
+	ArchiveDir string         `yaml:"archive-dir"`
+	Token      string         `yaml:"token"`
+	// Jobs is the number of model files to hash and look up concurrently. It doesn't limit downloads, which run
+	// one at a time.
+	Jobs int `yaml:"jobs"`
+	// AnyBaseModel offers newer versions even if their base models differ from the current version's.
+	AnyBaseModel bool `yaml:"any-base-model"`
+	// APIURL is the base URL of the Civitai API, such as a mirror or a caching proxy.
+	APIURL    string        `yaml:"api-url"`
+	Proxy     string        `yaml:"proxy"`
+	Timeout   time.Duration `yaml:"timeout"`
+	CABundle  string        `yaml:"ca-bundle"`
+	UserAgent string        `yaml:"user-agent"`
+	// Attempts is the maximum number of attempts of each request to retry temporary failures.
+	Attempts int `yaml:"attempts"`
+	// RateLimit and CacheTTL are pointers since zero disables them.
+	RateLimit *float64       `yaml:"rate-limit"`
+	CacheTTL  *time.Duration `yaml:"cache-ttl"`
+}
+
+// TargetConfig is a file or directory to check for updates with settings overriding the global ones.
+// It can also be written as a plain path.
+type TargetConfig struct {
+	Path    string   `yaml:"path"`
+	Format  string   `yaml:"format"`
+	Policy  Policy   `yaml:"policy"`
+	Exclude []string `yaml:"exclude"`
+}
+
+func (t *TargetConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		t.Path = value.Value
+		return nil
+	}
+
+	// an alias type avoids calling this method recursively.
+	type plain TargetConfig
+	return value.Decode((*plain)(t))
+}
+
+// defaultConfigPaths returns paths to look for a config file in order.
+func defaultConfigPaths(root string) []string {
+	res := []string{filepath.Join(root, configFile)}
+	if dir, err := os.UserConfigDir(); err == nil {
+		res = append(res, filepath.Join(dir, "sd-model-updater", "config.yaml"))
+	}
+	return res
+}
+
+// loadConfig reads the named config file. If the name is empty, it reads the first file found in the default paths,
+// or returns an empty config if there are no config files.
+func loadConfig(name, root string) (*Config, error) {
+	if name == "" {
+		for _, p := range defaultConfigPaths(root) {
+			if _, err := os.Stat(p); err == nil {
+				name = p
+				break
+			}
+		}
+		if name == "" {
+			return new(Config), nil
+		}
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(Config)
+	if err = yaml.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", name, err)
+	}
+	if err = res.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %v: %w", name, err)
+	}
+	return res, nil
+}
+
+// validate checks that the config has only known values and valid patterns.
+func (c *Config) validate() error {
+	var errs []error
+	checkFormat := func(s string) {
+		if s != "" {
+			if _, err := parseFormat(s); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %v", err, s))
+			}
+		}
+	}
+	checkPolicy := func(p Policy) {
+		if p != "" {
+			if _, err := parsePolicy(string(p)); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %v", err, p))
+			}
+		}
+	}
+	checkExclude := func(patterns []string) {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %v", err, p))
+			}
+		}
+	}
+
+	if c.Layout != "" {
+		if _, err := parseLayout(c.Layout); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %v", err, c.Layout))
+		}
+	}
+	checkFormat(c.Format)
+	checkPolicy(c.Policy)
+	checkExclude(c.Exclude)
+	if c.Old != "" {
+		if _, err := parseOldVersionMode(string(c.Old)); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %v", err, c.Old))
+		}
+	}
+	if c.Jobs < 0 {
+		errs = append(errs, fmt.Errorf("jobs must be positive: %v", c.Jobs))
+	}
+	if c.Attempts < 0 {
+		errs = append(errs, fmt.Errorf("attempts must be positive: %v", c.Attempts))
+	}
+	if c.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("timeout must be positive: %v", c.Timeout))
+	}
+	if c.RateLimit != nil && *c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate-limit must be positive: %v", *c.RateLimit))
+	}
+	if c.CacheTTL != nil && *c.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("cache-ttl must be positive: %v", *c.CacheTTL))
+	}
+	for _, t := range c.Targets {
+		if t.Path == "" {
+			errs = append(errs, errors.New("target path is empty"))
+		}
+		checkFormat(t.Format)
+		checkPolicy(t.Policy)
+		checkExclude(t.Exclude)
+	}
+	return errors.Join(errs...)
+}
+
+// target is a file or directory to check for updates with the settings applied to it.
+type target struct {
+	Path    string
+	Format  string
+	Policy  Policy
+	Exclude []string
+}
+
+// firstNonEmpty returns the first non-empty value.
+func firstNonEmpty[T ~string](values ...T) T {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// isExcluded returns true if the given path in the given directory matches any of the patterns.
+// Patterns are matched against the path relative to the directory and the base name.
+func isExcluded(dir, name string, patterns []string) bool {
+	rel, err := filepath.Rel(dir, name)
+	if err != nil {
+		rel = name
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+// config_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_loadConfig(t *testing.T) {
+	t.Run("valid config", func(t *testing.T) {
+		root := t.TempDir()
+		err := os.WriteFile(filepath.Join(root, configFile), []byte(`
+targets:
+  - models/Lora
+  - path: models/Stable-diffusion
+    format: pickle
+    policy: none
+    exclude: ["old"]
+layout: comfyui
+format: safetensor
+exclude: ["*.ckpt"]
+policy: latest
+old: archive
+archive-dir: /archive
+token: secret
+jobs: 4
+any-base-model: true
+api-url: http://localhost:8080/api/v1
+proxy: http://proxy:3128
+timeout: 30s
+ca-bundle: /etc/ssl/ca.pem
+user-agent: test
+attempts: 2
+rate-limit: 0
+cache-ttl: 10m
+`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := loadConfig("", root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// zero rate limit is kept to disable the limiter.
+		rateLimit, cacheTTL := 0.0, 10*time.Minute
+		expect := &Config{
+			Targets: []TargetConfig{
+				{Path: "models/Lora"},
+				{Path: "models/Stable-diffusion", Format: PickleFormat, Policy: PolicyNone, Exclude: []string{"old"}},
+			},
+			Layout:       LayoutComfyUI.Name,
+			Format:       SafetensorFormat,
+			Exclude:      []string{"*.ckpt"},
+			Policy:       PolicyLatest,
+			Old:          OldVersionArchive,
+			ArchiveDir:   "/archive",
+			Token:        "secret",
+			Jobs:         4,
+			AnyBaseModel: true,
+			APIURL:       "http://localhost:8080/api/v1",
+			Proxy:        "http://proxy:3128",
+			Timeout:      30 * time.Second,
+			CABundle:     "/etc/ssl/ca.pem",
+			UserAgent:    "test",
+			Attempts:     2,
+			RateLimit:    &rateLimit,
+			CacheTTL:     &cacheTTL,
+		}
+		if !reflect.DeepEqual(res, expect) {
+			t.Errorf("expect %+v, got %+v", expect, res)
+		}
+	})
+
+	t.Run("no config", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv("HOME", t.TempDir())
+		t.Setenv("AppData", t.TempDir())
+
+		res, err := loadConfig("", t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, new(Config)) {
+			t.Errorf("expect an empty config, got %+v", res)
+		}
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		if _, err := loadConfig(filepath.Join(t.TempDir(), "config.yaml"), ""); err == nil {
+			t.Error("expect an error")
+		}
+	})
+
+	invalid := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown layout", data: "layout: fooocus"},
+		{name: "unknown format", data: "format: gguf"},
+		{name: "unknown policy", data: "policy: sometimes"},
+		{name: "unknown old version mode", data: "old: rename"},
+		{name: "negative jobs", data: "jobs: -1"},
+		{name: "negative timeout", data: "timeout: -1s"},
+		{name: "negative attempts", data: "attempts: -1"},
+		{name: "negative rate limit", data: "rate-limit: -1"},
+		{name: "negative cache TTL", data: "cache-ttl: -1h"},
+		{name: "bad pattern", data: `exclude: ["["]`},
+		{name: "unknown format of a target", data: "targets: [{path: models/Lora, format: gguf}]"},
+		{name: "target without path", data: "targets: [{format: pickle}]"},
+		{name: "broken yaml", data: "targets: ["},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(name, []byte(c.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadConfig(name, ""); err == nil {
+				t.Error("expect an error")
+			}
+		})
+	}
+}
+
+func Test_isExcluded(t *testing.T) {
+	dir := filepath.Join("models", "Lora")
+	patterns := []string{"old", "*.ckpt", "styles/wip_*"}
+
+	cases := []struct {
+		name   string
+		expect bool
+	}{
+		{name: filepath.Join(dir, "old"), expect: true},
+		{name: filepath.Join(dir, "characters", "old"), expect: true},
+		{name: filepath.Join(dir, "model.ckpt"), expect: true},
+		{name: filepath.Join(dir, "styles", "wip_model.safetensors"), expect: true},
+		{name: filepath.Join(dir, "model.safetensors")},
+		{name: filepath.Join(dir, "characters", "wip_model.safetensors")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := isExcluded(dir, c.name, patterns); res != c.expect {
+				t.Errorf("expect %v, got %v", c.expect, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// hashcache.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+const hashCacheFile = "hashes.json"
+
+// hashCacheEntry is cached hashes of a file together with the size and modification time
+// the file had when the hashes were computed. SHA256 is computed only for files that need it.
+type hashCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	BLAKE3  string    `json:"blake3,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// hashCache is an on-disk cache of BLAKE3 and SHA256 hashes keyed by absolute file paths.
+// An entry is used only if the size and modification time of the file haven't changed.
+//
+// If webUI is set, hashes computed by the web UI are also used to look up models.
+type hashCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry
+	webUI   *webUICache
+}
+
+// defaultHashCachePath returns the path to the hash cache in the user cache directory.
+func defaultHashCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sd-model-updater", hashCacheFile), nil
+}
+
+// newHashCache creates an empty hash cache that will be stored in the given file.
+func newHashCache(name string) *hashCache {
+	return &hashCache{
+		path:    name,
+		entries: make(map[string]hashCacheEntry),
+	}
+}
+
+// loadHashCache reads the hash cache stored in the given file.
+// If the file doesn't exist, it returns an empty cache that will be stored in the file.
+func loadHashCache(name string) (*hashCache, error) {
+	c := newHashCache(name)
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// fileHash returns the BLAKE3 hash of the given named file.
+// It reads the file only if the cache doesn't have a fresh entry for it, and shows the progress in the given bar.
+// If the bar is nil, it starts a new progress bar. A nil cache always reads the file.
+func (c *hashCache) fileHash(name string, bar *pb.ProgressBar) (string, error) {
+	return c.hash(name, bar, func(e *hashCacheEntry) *string { return &e.BLAKE3 }, fileHashWithBar)
+}
+
+// fileSHA256 returns the SHA256 hash of the given named file in the same way as fileHash.
+func (c *hashCache) fileSHA256(name string, bar *pb.ProgressBar) (string, error) {
+	return c.hash(name, bar, func(e *hashCacheEntry) *string { return &e.SHA256 },
+		func(name string, bar *pb.ProgressBar) (string, error) {
+			return hashFileWithBar(name, sha256.New(), bar)
+		})
+}
+
+// hash returns the hash of the given named file stored in the field of its entry, or computes the hash with the given
+// function and stores it if the entry doesn't have a fresh one.
+func (c *hashCache) hash(
+	name string, bar *pb.ProgressBar,
+	field func(*hashCacheEntry) *string, compute func(string, *pb.ProgressBar) (string, error),
+) (string, error) {
+	if c == nil {
+		return compute(name, bar)
+	}
+
+	key, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(key)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	fresh := ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+	if fresh && *field(&e) != "" {
+		return *field(&e), nil
+	}
+
+	hash, err := compute(key, bar)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// another goroutine may have stored the other hash of the file meanwhile.
+	if e, ok = c.entries[key]; !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		e = hashCacheEntry{Size: info.Size(), ModTime: info.ModTime()}
+	}
+	*field(&e) = hash
+	c.entries[key] = e
+	return hash, nil
+}
+
+// lookupHash returns a hash of the given named file to look up the model on Civitai.
+// It prefers a fresh SHA256 hash computed by the web UI and falls back to the BLAKE3 hash.
+func (c *hashCache) lookupHash(name string, bar *pb.ProgressBar) (string, error) {
+	if c != nil {
+		if hash, ok := c.webUI.sha256(name); ok {
+			return hash, nil
+		}
+	}
+	return c.fileHash(name, bar)
+}
+
+// save writes the cache to the file it was loaded from. Entries of files that no longer exist are dropped.
+func (c *hashCache) save() (err error) {
+	if c == nil || c.path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.entries {
+		if _, e := os.Stat(k); errors.Is(e, os.ErrNotExist) {
+			delete(c.entries, k)
+		}
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that an interrupted run doesn't leave a broken cache.
+	f, err := os.CreateTemp(filepath.Dir(c.path), hashCacheFile+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, os.Remove(f.Name()))
+		}
+	}()
+
+	_, err = f.Write(data)
+	if err = errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
@@ -0,0 +1,178 @@
+// hashcache_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_hashCache(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "model.safetensors")
+	if err := os.WriteFile(target, []byte("model v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cacheFile := filepath.Join(dir, "cache", hashCacheFile)
+
+	c, err := loadHashCache(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 {
+		t.Fatalf("expect an empty cache, got %v", c.entries)
+	}
+
+	expect := modelHash(t, target)
+	res, err := c.fileHash(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != expect {
+		t.Errorf("expect %v, got %v", expect, res)
+	}
+	if err = c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("cache hit", func(t *testing.T) {
+		c, err := loadHashCache(cacheFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a fake hash proves the file isn't read again.
+		key, err := filepath.Abs(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := c.entries[key]
+		e.BLAKE3 = "cached"
+		c.entries[key] = e
+
+		res, err := c.fileHash(target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != "cached" {
+			t.Errorf("expect cached, got %v", res)
+		}
+	})
+
+	t.Run("modified file", func(t *testing.T) {
+		c, err := loadHashCache(cacheFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.WriteFile(target, []byte("model v2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Hour)
+		if err = os.Chtimes(target, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := modelHash(t, target)
+		res, err := c.fileHash(target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != expect {
+			t.Errorf("expect %v, got %v", expect, res)
+		}
+	})
+
+	t.Run("removed file", func(t *testing.T) {
+		c, err := loadHashCache(cacheFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.Remove(target); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.save(); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err = loadHashCache(cacheFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.entries) != 0 {
+			t.Errorf("expect an empty cache, got %v", c.entries)
+		}
+	})
+}
+
+func Test_hashCache_sha256(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "model.safetensors")
+	if err := os.WriteFile(target, []byte("model v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadHashCache(filepath.Join(dir, hashCacheFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blake3Hash, err := c.fileHash(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("model v1"))
+	expect := hex.EncodeToString(sum[:])
+	res, err := c.fileSHA256(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != expect {
+		t.Errorf("expect %v, got %v", expect, res)
+	}
+
+	key, err := filepath.Abs(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := c.entries[key]
+	if e.BLAKE3 != blake3Hash || e.SHA256 != expect {
+		t.Errorf("expect both hashes are cached, got %+v", e)
+	}
+
+	// a fake hash proves the file isn't read again.
+	e.SHA256 = "cached"
+	c.entries[key] = e
+	if res, err = c.fileSHA256(target, nil); err != nil {
+		t.Fatal(err)
+	}
+	if res != "cached" {
+		t.Errorf("expect cached, got %v", res)
+	}
+}
+
+func Test_hashCache_nil(t *testing.T) {
+	target := "README.md"
+
+	var c *hashCache
+	res, err := c.fileHash(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := modelHash(t, target); res != expect {
+		t.Errorf("expect %v, got %v", expect, res)
+	}
+	if err = c.save(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,321 @@
+// huggingface.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/go-openapi/strfmt"
+	"github.com/jkawamoto/go-civitai/models"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	// huggingFaceEndpoint is the default endpoint of the Hugging Face Hub.
+	huggingFaceEndpoint = "https://huggingface.co"
+	// huggingFaceBranch is the branch checked for newer revisions.
+	huggingFaceBranch = "main"
+)
+
+// HuggingFaceRef identifies a file in a Hugging Face repository.
+// It is stored in the sidecar file of a model file downloaded from the repository.
+type HuggingFaceRef struct {
+	Repo     string `json:"repo"`
+	Revision string `json:"revision"`
+	Filename string `json:"filename"`
+}
+
+// modelName returns the name of the model the referenced file is, which is unique across repositories.
+func (r HuggingFaceRef) modelName() string {
+	return r.Repo + ":" + r.Filename
+}
+
+// parseHuggingFaceModelName parses the given model name returned by HuggingFaceRef.modelName.
+func parseHuggingFaceModelName(name string) (HuggingFaceRef, error) {
+	repo, filename, ok := strings.Cut(name, ":")
+	if !ok || repo == "" || filename == "" {
+		return HuggingFaceRef{}, fmt.Errorf("%w: %v", ErrModelNotFound, name)
+	}
+	return HuggingFaceRef{Repo: repo, Filename: filename}, nil
+}
+
+// readHuggingFaceRef reads the sidecar file of the given model file.
+func readHuggingFaceRef(name string) (*HuggingFaceRef, error) {
+	data, err := os.ReadFile(sidecarPath(name, huggingFaceExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v has no %v", ErrModelNotFound, filepath.Base(name), huggingFaceExt)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var res HuggingFaceRef
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", sidecarPath(name, huggingFaceExt), err)
+	}
+	if res.Repo == "" || res.Filename == "" {
+		return nil, fmt.Errorf("%w: %v has no repo or filename", ErrModelNotFound, sidecarPath(name, huggingFaceExt))
+	}
+	if res.Revision == "" {
+		res.Revision = huggingFaceBranch
+	}
+	return &res, nil
+}
+
+// HuggingFace is a Source that tracks revisions of model files downloaded from Hugging Face repositories.
+// Each model file must have a sidecar file storing its HuggingFaceRef; a version is a commit touching the file.
+type HuggingFace struct {
+	httpClient *http.Client
+	endpoint   string
+	token      string
+	hashes     *hashCache
+}
+
+func NewHuggingFace() HuggingFace {
+	return HuggingFace{endpoint: huggingFaceEndpoint}
+}
+
+// WithHTTPClient returns a copy of the source that sends requests with the given HTTP client.
+func (hf HuggingFace) WithHTTPClient(httpClient *http.Client) HuggingFace {
+	hf.httpClient = httpClient
+	return hf
+}
+
+// WithToken returns a copy of the source that authenticates requests with the given Hugging Face access token.
+func (hf HuggingFace) WithToken(token string) HuggingFace {
+	hf.token = token
+	return hf
+}
+
+// WithHashCache returns a copy of the source that looks up SHA256 hashes of local files in the given cache.
+func (hf HuggingFace) WithHashCache(hashes *hashCache) HuggingFace {
+	hf.hashes = hashes
+	return hf
+}
+
+// pathInfo is information about a file in a repository returned by the paths-info API.
+type pathInfo struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	LFS  *struct {
+		// OID is the SHA256 hash of the file.
+		OID string `json:"oid"`
+	} `json:"lfs"`
+	LastCommit *struct {
+		ID    string    `json:"id"`
+		Title string    `json:"title"`
+		Date  time.Time `json:"date"`
+	} `json:"lastCommit"`
+}
+
+// newRequest creates a request to the Hub with the token.
+func (hf HuggingFace) newRequest(ctx context.Context, method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if hf.token != "" {
+		req.Header.Set("Authorization", "Bearer "+hf.token)
+	}
+	return req, nil
+}
+
+// version returns the version of the referenced file, which is the last commit touching the file in the revision.
+func (hf HuggingFace) version(ctx context.Context, ref HuggingFaceRef) (_ *models.ModelVersion, err error) {
+	u := fmt.Sprintf("%v/api/models/%v/paths-info/%v", hf.endpoint, ref.Repo, url.PathEscape(ref.Revision))
+	form := url.Values{"paths": {ref.Filename}, "expand": {"true"}}
+	req, err := hf.newRequest(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := ctxhttp.Do(ctx, hf.httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, res.Body.Close())
+	}()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %v@%v", ErrModelNotFound, ref.Repo, ref.Revision)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrGetFailure, res.Status)
+	}
+
+	var infos []pathInfo
+	if err = json.NewDecoder(res.Body).Decode(&infos); err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 || infos[0].LFS == nil || infos[0].LastCommit == nil {
+		return nil, fmt.Errorf("%w: %v in %v@%v", ErrModelNotFound, ref.Filename, ref.Repo, ref.Revision)
+	}
+
+	info := infos[0]
+	commit := info.LastCommit.ID
+	return &models.ModelVersion{
+		Name:        commit[:min(len(commit), 7)],
+		Description: info.LastCommit.Title,
+		PublishedAt: strfmt.DateTime(info.LastCommit.Date),
+		Files: []*models.File{{
+			Name:        path.Base(ref.Filename),
+			SizeKB:      float64(info.Size) / 1024,
+			Primary:     true,
+			DownloadURL: fmt.Sprintf("%v/%v/resolve/%v/%v", hf.endpoint, ref.Repo, commit, ref.Filename),
+			Hashes:      &models.Hash{SHA256: info.LFS.OID},
+		}},
+	}, nil
+}
+
+// LookupHash reads the sidecar file of the given model file and returns the version of the file.
+// It also compares the SHA256 hash of the file with the one stored in LFS, and returns an error wrapping
+// ErrModelNotFound if they differ, because the sidecar doesn't describe the file.
+func (hf HuggingFace) LookupHash(ctx context.Context, name, hash string) (*models.Model, *models.ModelVersion, error) {
+	ref, err := readHuggingFaceRef(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ver, err := hf.version(ctx, *ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expect := ver.Files[0].Hashes.SHA256
+	if !strings.EqualFold(hash, expect) {
+		// the given hash is BLAKE3 unless the web UI has the SHA256 hash of the file.
+		sha, err := hf.hashes.fileSHA256(name, hashBar(ctx))
+		if err != nil {
+			return nil, nil, err
+		}
+		if !strings.EqualFold(sha, expect) {
+			return nil, nil, fmt.Errorf("%w: %v doesn't match %v in %v@%v",
+				ErrModelNotFound, filepath.Base(name), ref.Filename, ref.Repo, ref.Revision)
+		}
+		// the file is verified, so the version also has its BLAKE3 hash to be found by it.
+		ver.Files[0].Hashes.BLAKE3 = hash
+	}
+	return &models.Model{Name: ref.modelName()}, ver, nil
+}
+
+// FetchModel returns the given model as it is since the model name has everything to find versions.
+func (hf HuggingFace) FetchModel(_ context.Context, m *models.Model) (*models.Model, error) {
+	return m, nil
+}
+
+// ListVersions returns the version of the file in the main branch.
+// Older commits are not listed since only newer versions are offered.
+func (hf HuggingFace) ListVersions(ctx context.Context, m *models.Model) ([]*models.ModelVersion, error) {
+	ref, err := parseHuggingFaceModelName(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	ref.Revision = huggingFaceBranch
+
+	ver, err := hf.version(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return []*models.ModelVersion{ver}, nil
+}
+
+// Download gets the file of the given version, stores it into the given directory with its sidecar file, and
+// returns the path to the stored file. If the directory has a file with the same name, which is usually an older
+// revision, the name gets the version name as a suffix.
+func (hf HuggingFace) Download(ctx context.Context, ver *models.ModelVersion, dir string) (_ string, err error) {
+	if len(ver.Files) == 0 {
+		return "", ErrFileNotFound
+	}
+	file := ver.Files[0]
+	ref, err := parseResolveURL(hf.endpoint, file.DownloadURL)
+	if err != nil {
+		return "", err
+	}
+
+	dest := filepath.Join(dir, file.Name)
+	if _, err = os.Stat(dest); err == nil {
+		ext := filepath.Ext(file.Name)
+		dest = filepath.Join(dir, fmt.Sprintf("%v-%v%v", strings.TrimSuffix(file.Name, ext), ver.Name, ext))
+	}
+	if _, err = os.Stat(dest); err == nil {
+		return "", fmt.Errorf("%v already exists: %w", dest, os.ErrExist)
+	}
+
+	req, err := hf.newRequest(ctx, http.MethodGet, file.DownloadURL, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := ctxhttp.Do(ctx, hf.httpClient, req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errors.Join(err, res.Body.Close())
+	}()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %v", ErrGetFailure, res.Status)
+	}
+
+	bar := pb.New64(int64(file.SizeKB * 1024))
+	bar.Set(pb.SIBytesPrefix, true)
+	bar.Set("prefix", filepath.Base(dest)+" ")
+	bar.Start()
+	defer bar.Finish()
+
+	part := dest + partFileExt
+	hash := sha256.New()
+	if err = writePartFile(part, 0, hash, bar.NewProxyReader(res.Body)); err != nil {
+		return "", errors.Join(err, os.Remove(part))
+	}
+	if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), file.Hashes.SHA256) {
+		return "", errors.Join(ErrFileHashNotMatch, os.Remove(part))
+	}
+	if err = os.Rename(part, dest); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(ref, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return dest, writeFile(sidecarPath(dest, huggingFaceExt), bytes.NewReader(data))
+}
+
+// parseResolveURL parses the given URL to download a file from the given endpoint.
+func parseResolveURL(endpoint, u string) (*HuggingFaceRef, error) {
+	p, ok := strings.CutPrefix(u, endpoint+"/")
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected URL %v", ErrGetFailure, u)
+	}
+	repo, rest, ok := strings.Cut(p, "/resolve/")
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected URL %v", ErrGetFailure, u)
+	}
+	revision, filename, ok := strings.Cut(rest, "/")
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected URL %v", ErrGetFailure, u)
+	}
+	return &HuggingFaceRef{Repo: repo, Revision: revision, Filename: filename}, nil
+}
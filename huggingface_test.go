@@ -0,0 +1,230 @@
+// huggingface_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newHubServer starts a stand-in for the Hugging Face Hub serving unet/model.safetensors in org/repo.
+// The given contents are committed in order, and the main branch points to the last commit.
+func newHubServer(t *testing.T, contents ...string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	now := time.Now().UTC()
+	for i, content := range contents {
+		commit := fmt.Sprintf("commit%v0123456789abcdef", i)
+		hash := sha256.Sum256([]byte(content))
+		info := fmt.Sprintf(`[{
+			"type": "file",
+			"path": "unet/model.safetensors",
+			"size": %d,
+			"lfs": {"oid": %q, "size": %d},
+			"lastCommit": {"id": %q, "title": "update to %v", "date": %q}
+		}]`, len(content), hex.EncodeToString(hash[:]), len(content), commit, content,
+			now.Add(time.Duration(i)*time.Hour).Format(time.RFC3339))
+
+		serveInfo := func(res http.ResponseWriter, req *http.Request) {
+			if req.FormValue("paths") != "unet/model.safetensors" || req.FormValue("expand") != "true" {
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if _, err := res.Write([]byte(info)); err != nil {
+				t.Error(err)
+			}
+		}
+		mux.HandleFunc("POST /api/models/org/repo/paths-info/"+commit, serveInfo)
+		if i == len(contents)-1 {
+			mux.HandleFunc("POST /api/models/org/repo/paths-info/main", serveInfo)
+		}
+		mux.HandleFunc("GET /org/repo/resolve/"+commit+"/unet/model.safetensors",
+			func(res http.ResponseWriter, _ *http.Request) {
+				if _, err := res.Write([]byte(content)); err != nil {
+					t.Error(err)
+				}
+			})
+	}
+	return server
+}
+
+// writeModelWithRef writes the given content into model.safetensors in the given directory with its sidecar file
+// referring to the given revision of unet/model.safetensors in org/repo.
+func writeModelWithRef(t *testing.T, dir, content, revision string) string {
+	t.Helper()
+
+	name := filepath.Join(dir, "model.safetensors")
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(HuggingFaceRef{Repo: "org/repo", Revision: revision, Filename: "unet/model.safetensors"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(sidecarPath(name, huggingFaceExt), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestHuggingFace(t *testing.T) {
+	ctx := context.Background()
+	server := newHubServer(t, "v1", "v2")
+	hf := NewHuggingFace()
+	hf.endpoint = server.URL
+	hf.httpClient = server.Client()
+
+	cli := (&fakeService{}).newClient(t)
+	opts := scanOptions{Sources: []Source{hf}}
+
+	t.Run("update", func(t *testing.T) {
+		dir := t.TempDir()
+		name := writeModelWithRef(t, dir, "v1", "commit00123456789abcdef")
+
+		u, err := findUpdate(ctx, cli, nil, name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.ModelName != "org/repo:unet/model.safetensors" || u.CurrentVersion != "commit0" {
+			t.Errorf("unexpected update: %+v", u)
+		}
+		if len(u.Candidates) != 1 || u.Candidates[0].Name != "commit1" || u.Candidates[0].Description != "update to v2" {
+			t.Fatalf("expect commit1 is the only candidate, got %v", u.Candidates)
+		}
+
+		err = u.run(ctx, cli, dir, updateOptions{Policy: PolicyLatest, Old: OldVersionDelete})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(name); err == nil {
+			t.Error("expect the old revision is deleted")
+		}
+		if _, err = os.Stat(sidecarPath(name, huggingFaceExt)); err == nil {
+			t.Error("expect the sidecar of the old revision is deleted")
+		}
+
+		// the old revision exists while downloading the new one, so the new one has the version name.
+		downloaded := filepath.Join(dir, "model-commit1.safetensors")
+		data, err := os.ReadFile(downloaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "v2" {
+			t.Errorf("expect v2, got %q", data)
+		}
+		ref, err := readHuggingFaceRef(downloaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := HuggingFaceRef{Repo: "org/repo", Revision: "commit10123456789abcdef", Filename: "unet/model.safetensors"}
+		if *ref != expect {
+			t.Errorf("expect %+v, got %+v", expect, *ref)
+		}
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		name := writeModelWithRef(t, t.TempDir(), "v2", "commit10123456789abcdef")
+
+		u, err := findUpdate(ctx, cli, nil, name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(u.Candidates) != 0 {
+			t.Errorf("expect no candidates, got %v", u.Candidates)
+		}
+	})
+
+	t.Run("unchanged in newer commit", func(t *testing.T) {
+		server := newHubServer(t, "v1", "v1")
+		hf := NewHuggingFace().WithHTTPClient(server.Client())
+		hf.endpoint = server.URL
+		name := writeModelWithRef(t, t.TempDir(), "v1", "commit00123456789abcdef")
+
+		u, err := findUpdate(ctx, cli, nil, name, scanOptions{Sources: []Source{hf}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(u.Candidates) != 0 {
+			t.Errorf("expect no candidates, got %v", u.Candidates)
+		}
+	})
+
+	t.Run("modified file", func(t *testing.T) {
+		name := writeModelWithRef(t, t.TempDir(), "modified", "commit00123456789abcdef")
+
+		if _, err := findUpdate(ctx, cli, nil, name, opts); !isNotFound(err) {
+			t.Errorf("expect a not found error, got %v", err)
+		}
+	})
+
+	t.Run("hash cache", func(t *testing.T) {
+		dir := t.TempDir()
+		name := writeModelWithRef(t, dir, "v2", "commit10123456789abcdef")
+		hashes, err := loadHashCache(filepath.Join(dir, hashCacheFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := scanOptions{Sources: []Source{hf.WithHashCache(hashes)}}
+
+		if _, err = findUpdate(ctx, cli, hashes, name, opts); err != nil {
+			t.Fatal(err)
+		}
+		key, err := filepath.Abs(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte("v2"))
+		if e := hashes.entries[key]; e.BLAKE3 == "" || e.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("expect both hashes are cached, got %+v", e)
+		}
+	})
+
+	t.Run("no sidecar", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "model.safetensors")
+		if err := os.WriteFile(name, []byte("v1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := findUpdate(ctx, cli, nil, name, opts); !isNotFound(err) {
+			t.Errorf("expect a not found error, got %v", err)
+		}
+	})
+}
+
+func Test_parseResolveURL(t *testing.T) {
+	const endpoint = "https://huggingface.co"
+	res, err := parseResolveURL(endpoint, endpoint+"/org/repo/resolve/abc/unet/model.safetensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &HuggingFaceRef{Repo: "org/repo", Revision: "abc", Filename: "unet/model.safetensors"}
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf("expect %+v, got %+v", expect, res)
+	}
+
+	for _, u := range []string{"https://example.com/org/repo/resolve/abc/a", endpoint + "/org/repo/blob/abc/a"} {
+		if _, err = parseResolveURL(endpoint, u); err == nil {
+			t.Errorf("expect an error for %v", u)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// install.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/fatih/color"
+)
+
+// ErrInvalidModelRef returns if the given string is neither a Civitai model URL nor an ID.
+var ErrInvalidModelRef = errors.New("neither a Civitai model URL nor an ID")
+
+// ErrUnknownModelType returns if the layout doesn't know where to store models of the given type.
+var ErrUnknownModelType = errors.New("no directory is known for the model type; give one with -dir")
+
+// ErrInstallFailed returns if some of the given models couldn't be installed.
+var ErrInstallFailed = errors.New("failed to install some models")
+
+// ErrNothingToInstall returns if the policy given to install doesn't download any versions.
+var ErrNothingToInstall = fmt.Errorf("policy %v doesn't install any models", PolicyNone)
+
+// modelVersionIDParam is the query parameter of model pages on Civitai that selects a version.
+const modelVersionIDParam = "modelVersionId"
+
+// modelRef identifies a model on Civitai, or a version of it if VersionID is not zero.
+// ModelID is zero if only the version is known.
+type modelRef struct {
+	ModelID   int64
+	VersionID int64
+}
+
+// parseModelRef parses one of the following forms:
+//
+//   - a model ID such as 1234,
+//   - a version ID such as modelVersionId=5678,
+//   - a model page URL such as https://civitai.com/models/1234/name?modelVersionId=5678,
+//   - a download URL such as https://civitai.com/api/download/models/5678, and
+//   - an API URL such as https://civitai.com/api/v1/models/1234 or https://civitai.com/api/v1/model-versions/5678.
+func parseModelRef(s string) (modelRef, error) {
+	parseID := func(s string) (int64, error) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidModelRef, s)
+		}
+		return id, nil
+	}
+
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		id, err := parseID(s)
+		return modelRef{ModelID: id}, err
+	}
+	if v, ok := strings.CutPrefix(s, modelVersionIDParam+"="); ok {
+		id, err := parseID(v)
+		return modelRef{VersionID: id}, err
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return modelRef{}, fmt.Errorf("%w: %v", ErrInvalidModelRef, s)
+	}
+
+	var res modelRef
+	switch segments := strings.Split(strings.Trim(u.Path, "/"), "/"); {
+	case len(segments) >= 2 && segments[0] == "models":
+		res.ModelID, err = parseID(segments[1])
+	case len(segments) == 4 && segments[0] == "api" && segments[1] == "v1" && segments[2] == "models":
+		res.ModelID, err = parseID(segments[3])
+	case len(segments) == 4 && segments[0] == "api" && segments[1] == "v1" && segments[2] == "model-versions":
+		res.VersionID, err = parseID(segments[3])
+	case len(segments) == 4 && segments[0] == "api" && segments[1] == "download" && segments[2] == "models":
+		res.VersionID, err = parseID(segments[3])
+	default:
+		return modelRef{}, fmt.Errorf("%w: %v", ErrInvalidModelRef, s)
+	}
+	if err != nil {
+		return modelRef{}, err
+	}
+	if v := u.Query().Get(modelVersionIDParam); v != "" && res.ModelID != 0 {
+		if res.VersionID, err = parseID(v); err != nil {
+			return modelRef{}, err
+		}
+	}
+	return res, nil
+}
+
+// install downloads the referenced model into the given directory.
+// If the directory is empty, it stores the model into the directory the layout expects for the model type.
+// If the reference has no version, it chooses versions according to the policy.
+func install(ctx context.Context, cli Client, ref modelRef, dir string, opts updateOptions) error {
+	if ref.ModelID == 0 {
+		id, err := cli.GetModelIDByVersion(ctx, ref.VersionID)
+		if err != nil {
+			return err
+		}
+		ref.ModelID = id
+	}
+
+	m, err := cli.GetModel(ctx, ref.ModelID)
+	if err != nil {
+		return err
+	}
+
+	u := Update{
+		ModelID:   m.ID,
+		ModelName: m.Name,
+		ModelType: string(m.Type),
+	}
+	for _, v := range m.ModelVersions {
+		if ref.VersionID == 0 || v.ID == ref.VersionID {
+			u.Candidates = append(u.Candidates, v)
+		}
+	}
+	sort.Stable(sort.Reverse(u.Candidates))
+	if len(u.Candidates) == 0 {
+		return fmt.Errorf("%w: version %v of %v", ErrModelNotFound, ref.VersionID, m.Name)
+	}
+
+	if dir != "" {
+		// the given directory is used as it is even if the layout expects another one.
+		opts.Layout = Layout{}
+	} else {
+		d, ok := opts.Layout.modelDir(u.ModelType)
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrUnknownModelType, u.ModelType)
+		}
+		dir = filepath.Join(opts.Root, d)
+	}
+	return u.run(ctx, cli, dir, opts)
+}
+
+// runInstall downloads models given by Civitai URLs or IDs.
+func runInstall(ctx context.Context, args []string) error {
+	fs := newFlagSet("install", "install [flags] <url or id> ...")
+	shared := newSharedFlags(fs)
+	shared.addDownloadFlags(PolicyLatest)
+	dir := fs.String("dir", "", "directory to store the models in (default the directory of the model type in the layout)")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: no models are given", ErrInvalidModelRef)
+	}
+
+	s, err := shared.settings()
+	if err != nil {
+		return err
+	}
+
+	cli, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	policy, err := installPolicy(s)
+	if err != nil {
+		return err
+	}
+	return installAll(ctx, cli, fs.Args(), *dir, updateOptions{
+		Policy: policy,
+		Root:   s.Root,
+		Layout: s.Layout,
+	})
+}
+
+// installPolicy returns the policy to choose versions to install. The policy in the config file is ignored since it
+// is for updates, which may be disabled with none while installing models is always intended.
+func installPolicy(s *settings) (Policy, error) {
+	policy := firstNonEmpty(s.Policy, s.DefaultPolicy)
+	if policy == PolicyNone {
+		return "", ErrNothingToInstall
+	}
+	return policy, nil
+}
+
+// installAll installs models given by Civitai URLs or IDs. It doesn't install any models if some of them are
+// invalid, and it goes on to the other models if one fails and returns an error wrapping ErrInstallFailed.
+func installAll(ctx context.Context, cli Client, args []string, dir string, opts updateOptions) error {
+	refs := make([]modelRef, len(args))
+	for i, arg := range args {
+		var err error
+		if refs[i], err = parseModelRef(arg); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for i, ref := range refs {
+		if err := install(ctx, cli, ref, dir, opts); err != nil {
+			if errors.Is(err, terminal.InterruptErr) || ctx.Err() != nil {
+				return err
+			}
+			fmt.Println(color.RedString("Failed to install %v: %v", args[i], err))
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%w: %v of %v models", ErrInstallFailed, failed, len(refs))
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// install_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+func Test_parseModelRef(t *testing.T) {
+	cases := []struct {
+		name   string
+		arg    string
+		expect modelRef
+		err    bool
+	}{
+		{name: "model id", arg: "1234", expect: modelRef{ModelID: 1234}},
+		{name: "version id", arg: "modelVersionId=5678", expect: modelRef{VersionID: 5678}},
+		{name: "model page", arg: "https://civitai.com/models/1234/some-model", expect: modelRef{ModelID: 1234}},
+		{
+			name:   "model page with a version",
+			arg:    "https://civitai.com/models/1234/some-model?modelVersionId=5678",
+			expect: modelRef{ModelID: 1234, VersionID: 5678},
+		},
+		{name: "model page without scheme", arg: "civitai.com/models/1234", expect: modelRef{ModelID: 1234}},
+		{name: "download url", arg: "https://civitai.com/api/download/models/5678", expect: modelRef{VersionID: 5678}},
+		{name: "model api", arg: "https://civitai.com/api/v1/models/1234", expect: modelRef{ModelID: 1234}},
+		{name: "version api", arg: "https://civitai.com/api/v1/model-versions/5678", expect: modelRef{VersionID: 5678}},
+		{name: "negative id", arg: "-1", err: true},
+		{name: "broken version id", arg: "modelVersionId=abc", err: true},
+		{name: "other page", arg: "https://civitai.com/images/1234", err: true},
+		{name: "broken model page", arg: "https://civitai.com/models/abc", err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := parseModelRef(c.arg)
+			if c.err {
+				if !errors.Is(err, ErrInvalidModelRef) {
+					t.Errorf("expect %v, got %v", ErrInvalidModelRef, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res != c.expect {
+				t.Errorf("expect %+v, got %+v", c.expect, res)
+			}
+		})
+	}
+}
+
+func Test_install(t *testing.T) {
+	ctx := context.Background()
+	const modelID = 100
+
+	server, versions := newVersionServer(t, "v1", "v2")
+	service := &fakeService{models: []*models.Model{{
+		ID:            modelID,
+		Name:          "model",
+		Type:          ModelTypeLORA,
+		ModelVersions: []*models.ModelVersion{versions["v1"], versions["v2"]},
+	}}}
+	cli := service.newClient(t)
+	cli.httpClient = server.Client()
+
+	cases := []struct {
+		name   string
+		ref    modelRef
+		dir    string
+		expect string
+		err    error
+	}{
+		{name: "model", ref: modelRef{ModelID: modelID}, expect: filepath.Join("models", "Lora", "v2.safetensors")},
+		{
+			name:   "model and version",
+			ref:    modelRef{ModelID: modelID, VersionID: versions["v1"].ID},
+			expect: filepath.Join("models", "Lora", "v1.safetensors"),
+		},
+		{
+			name:   "version",
+			ref:    modelRef{VersionID: versions["v1"].ID},
+			expect: filepath.Join("models", "Lora", "v1.safetensors"),
+		},
+		{
+			name:   "given directory",
+			ref:    modelRef{ModelID: modelID},
+			dir:    "others",
+			expect: filepath.Join("others", "v2.safetensors"),
+		},
+		{name: "unknown model", ref: modelRef{ModelID: modelID + 1}, err: notFoundError{}},
+		{name: "unknown version", ref: modelRef{ModelID: modelID, VersionID: 1000}, err: ErrModelNotFound},
+		{name: "unknown version without model", ref: modelRef{VersionID: 1000}, err: ErrModelNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := c.dir
+			if dir != "" {
+				dir = filepath.Join(root, dir)
+			}
+
+			err := install(ctx, cli, c.ref, dir, updateOptions{
+				Policy: PolicyLatest,
+				Root:   root,
+				Layout: LayoutAutomatic1111,
+			})
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("expect %v, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(filepath.Join(root, c.expect)); err != nil {
+				t.Error(err)
+			}
+
+			data, err := os.ReadFile(sidecarPath(filepath.Join(root, c.expect), civitaiInfoExt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var info struct {
+				ModelID int64 `json:"modelId"`
+			}
+			if err = json.Unmarshal(data, &info); err != nil {
+				t.Fatal(err)
+			}
+			if info.ModelID != modelID {
+				t.Errorf("expect the info has model %v, got %v", modelID, info.ModelID)
+			}
+		})
+	}
+}
+
+func Test_installAll(t *testing.T) {
+	ctx := context.Background()
+	const modelID = 100
+
+	server, versions := newVersionServer(t, "v1")
+	cli := NewClient(SafetensorFormat)
+	cli.httpClient = server.Client()
+	cli.clientService = &fakeService{models: []*models.Model{{
+		ID:            modelID,
+		Name:          "model",
+		Type:          ModelTypeLORA,
+		ModelVersions: []*models.ModelVersion{versions["v1"]},
+	}}}
+
+	cases := []struct {
+		name   string
+		args   []string
+		expect bool
+		err    error
+	}{
+		{name: "success", args: []string{"100"}, expect: true},
+		{name: "partial failure", args: []string{"101", "100"}, expect: true, err: ErrInstallFailed},
+		{name: "invalid reference", args: []string{"100", "not-a-model"}, err: ErrInvalidModelRef},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := t.TempDir()
+			err := installAll(ctx, cli, c.args, "", updateOptions{
+				Policy: PolicyLatest,
+				Root:   root,
+				Layout: LayoutAutomatic1111,
+			})
+			if (c.err == nil && err != nil) || (c.err != nil && !errors.Is(err, c.err)) {
+				t.Errorf("expect %v, got %v", c.err, err)
+			}
+			_, err = os.Stat(filepath.Join(root, "models", "Lora", "v1.safetensors"))
+			if c.expect != (err == nil) {
+				t.Errorf("expect the model is installed: %v, got %v", c.expect, err)
+			}
+		})
+	}
+}
+
+func Test_installPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		flag   Policy
+		config Policy
+		expect Policy
+		err    error
+	}{
+		{name: "default", expect: PolicyLatest},
+		{name: "flag", flag: PolicyAll, expect: PolicyAll},
+		{name: "config is ignored", config: PolicyNone, expect: PolicyLatest},
+		{name: "none", flag: PolicyNone, err: ErrNothingToInstall},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := installPolicy(&settings{
+				Config:        &Config{Policy: c.config},
+				Policy:        c.flag,
+				DefaultPolicy: PolicyLatest,
+			})
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expect %v, got %v", c.err, err)
+			}
+			if res != c.expect {
+				t.Errorf("expect %v, got %v", c.expect, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// layout.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnknownLayout returns if the given layout is not supported.
+var ErrUnknownLayout = fmt.Errorf("unknown layout is specified")
+
+// Civitai model types.
+const (
+	ModelTypeCheckpoint       = "Checkpoint"
+	ModelTypeTextualInversion = "TextualInversion"
+	ModelTypeHypernetwork     = "Hypernetwork"
+	ModelTypeLORA             = "LORA"
+	ModelTypeLoCon            = "LoCon"
+	ModelTypeDoRA             = "DoRA"
+	ModelTypeVAE              = "VAE"
+	ModelTypeControlnet       = "Controlnet"
+	ModelTypeUpscaler         = "Upscaler"
+)
+
+// Layout describes where a web UI stores models.
+type Layout struct {
+	Name string
+	// Markers are files or directories that exist in the root directory of the web UI.
+	// A layout is detected if all of them exist.
+	Markers []string
+	// Targets are directories checked for updates by default.
+	Targets []string
+	// Dirs maps Civitai model types to directories the web UI loads them from.
+	Dirs map[string]string
+}
+
+// modelDir returns the directory models of the given Civitai model type should be stored in.
+func (l Layout) modelDir(modelType string) (string, bool) {
+	for t, dir := range l.Dirs {
+		if strings.EqualFold(t, modelType) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+var (
+	// LayoutAutomatic1111 is the layout of AUTOMATIC1111's Stable Diffusion web UI.
+	LayoutAutomatic1111 = Layout{
+		Name:    "automatic1111",
+		Markers: []string{"webui.py"},
+		Targets: []string{
+			filepath.Join("models", "hypernetworks"),
+			filepath.Join("models", "Lora"),
+			filepath.Join("models", "Stable-diffusion"),
+			filepath.Join("models", "VAE"),
+			"embeddings",
+		},
+		Dirs: map[string]string{
+			ModelTypeCheckpoint:       filepath.Join("models", "Stable-diffusion"),
+			ModelTypeTextualInversion: "embeddings",
+			ModelTypeHypernetwork:     filepath.Join("models", "hypernetworks"),
+			ModelTypeLORA:             filepath.Join("models", "Lora"),
+			ModelTypeLoCon:            filepath.Join("models", "Lora"),
+			ModelTypeDoRA:             filepath.Join("models", "Lora"),
+			ModelTypeVAE:              filepath.Join("models", "VAE"),
+			ModelTypeControlnet:       filepath.Join("models", "ControlNet"),
+			ModelTypeUpscaler:         filepath.Join("models", "ESRGAN"),
+		},
+	}
+
+	// LayoutForge is the layout of Stable Diffusion WebUI Forge, which follows AUTOMATIC1111's web UI.
+	LayoutForge = Layout{
+		Name:    "forge",
+		Markers: []string{"webui.py", "modules_forge"},
+		Targets: LayoutAutomatic1111.Targets,
+		Dirs:    LayoutAutomatic1111.Dirs,
+	}
+
+	// LayoutSDNext is the layout of SD.Next.
+	LayoutSDNext = Layout{
+		Name:    "sdnext",
+		Markers: []string{"webui.py", "installer.py"},
+		Targets: []string{
+			filepath.Join("models", "hypernetworks"),
+			filepath.Join("models", "Lora"),
+			filepath.Join("models", "Stable-diffusion"),
+			filepath.Join("models", "VAE"),
+			filepath.Join("models", "embeddings"),
+		},
+		Dirs: map[string]string{
+			ModelTypeCheckpoint:       filepath.Join("models", "Stable-diffusion"),
+			ModelTypeTextualInversion: filepath.Join("models", "embeddings"),
+			ModelTypeHypernetwork:     filepath.Join("models", "hypernetworks"),
+			ModelTypeLORA:             filepath.Join("models", "Lora"),
+			ModelTypeLoCon:            filepath.Join("models", "Lora"),
+			ModelTypeDoRA:             filepath.Join("models", "Lora"),
+			ModelTypeVAE:              filepath.Join("models", "VAE"),
+			ModelTypeControlnet:       filepath.Join("models", "control", "controlnet"),
+			ModelTypeUpscaler:         filepath.Join("models", "ESRGAN"),
+		},
+	}
+
+	// LayoutComfyUI is the layout of ComfyUI.
+	LayoutComfyUI = Layout{
+		Name:    "comfyui",
+		Markers: []string{"comfy", "main.py"},
+		Targets: []string{
+			filepath.Join("models", "checkpoints"),
+			filepath.Join("models", "embeddings"),
+			filepath.Join("models", "hypernetworks"),
+			filepath.Join("models", "loras"),
+			filepath.Join("models", "upscale_models"),
+			filepath.Join("models", "vae"),
+		},
+		Dirs: map[string]string{
+			ModelTypeCheckpoint:       filepath.Join("models", "checkpoints"),
+			ModelTypeTextualInversion: filepath.Join("models", "embeddings"),
+			ModelTypeHypernetwork:     filepath.Join("models", "hypernetworks"),
+			ModelTypeLORA:             filepath.Join("models", "loras"),
+			ModelTypeLoCon:            filepath.Join("models", "loras"),
+			ModelTypeDoRA:             filepath.Join("models", "loras"),
+			ModelTypeVAE:              filepath.Join("models", "vae"),
+			ModelTypeControlnet:       filepath.Join("models", "controlnet"),
+			ModelTypeUpscaler:         filepath.Join("models", "upscale_models"),
+		},
+	}
+
+	// LayoutInvokeAI is the layout of InvokeAI. InvokeAI manages installed models by itself,
+	// so new models are stored in the autoimport directories, which InvokeAI scans at startup.
+	LayoutInvokeAI = Layout{
+		Name:    "invokeai",
+		Markers: []string{"invokeai.yaml"},
+		Targets: []string{
+			"models",
+			"autoimport",
+		},
+		Dirs: map[string]string{
+			ModelTypeCheckpoint:       filepath.Join("autoimport", "main"),
+			ModelTypeTextualInversion: filepath.Join("autoimport", "embedding"),
+			ModelTypeLORA:             filepath.Join("autoimport", "lora"),
+			ModelTypeLoCon:            filepath.Join("autoimport", "lora"),
+			ModelTypeDoRA:             filepath.Join("autoimport", "lora"),
+			ModelTypeVAE:              filepath.Join("autoimport", "vae"),
+			ModelTypeControlnet:       filepath.Join("autoimport", "controlnet"),
+		},
+	}
+)
+
+// layouts is a list of supported layouts in the order of detection;
+// layouts with more specific markers come first.
+var layouts = []Layout{
+	LayoutInvokeAI,
+	LayoutComfyUI,
+	LayoutForge,
+	LayoutSDNext,
+	LayoutAutomatic1111,
+}
+
+// layoutNames returns the names of supported layouts.
+func layoutNames() []string {
+	res := make([]string, len(layouts))
+	for i, l := range layouts {
+		res[i] = l.Name
+	}
+	return res
+}
+
+// parseLayout returns the layout of the given name.
+func parseLayout(s string) (Layout, error) {
+	for _, l := range layouts {
+		if strings.EqualFold(l.Name, s) {
+			return l, nil
+		}
+	}
+	return Layout{}, ErrUnknownLayout
+}
+
+// detectLayout returns the layout of the web UI installed in the given root directory.
+// If no markers are found, it assumes AUTOMATIC1111's web UI.
+func detectLayout(root string) Layout {
+	for _, l := range layouts {
+		found := true
+		for _, m := range l.Markers {
+			if _, err := os.Stat(filepath.Join(root, m)); err != nil {
+				found = false
+				break
+			}
+		}
+		if found {
+			return l
+		}
+	}
+	return LayoutAutomatic1111
+}
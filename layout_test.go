@@ -0,0 +1,83 @@
+// layout_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_detectLayout(t *testing.T) {
+	cases := []struct {
+		name    string
+		markers []string
+		expect  Layout
+	}{
+		{name: "no markers", expect: LayoutAutomatic1111},
+		{name: "automatic1111", markers: []string{"webui.py", "launch.py"}, expect: LayoutAutomatic1111},
+		{name: "forge", markers: []string{"webui.py", "modules_forge"}, expect: LayoutForge},
+		{name: "sdnext", markers: []string{"webui.py", "installer.py"}, expect: LayoutSDNext},
+		{name: "comfyui", markers: []string{"comfy", "main.py"}, expect: LayoutComfyUI},
+		{name: "invokeai", markers: []string{"invokeai.yaml"}, expect: LayoutInvokeAI},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, m := range c.markers {
+				if err := os.WriteFile(filepath.Join(root, m), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if res := detectLayout(root); res.Name != c.expect.Name {
+				t.Errorf("expect %v, got %v", c.expect.Name, res.Name)
+			}
+		})
+	}
+}
+
+func Test_parseLayout(t *testing.T) {
+	for _, l := range layouts {
+		res, err := parseLayout(l.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Name != l.Name {
+			t.Errorf("expect %v, got %v", l.Name, res.Name)
+		}
+	}
+
+	if _, err := parseLayout("unknown"); err != ErrUnknownLayout {
+		t.Errorf("expect %v, got %v", ErrUnknownLayout, err)
+	}
+}
+
+func TestLayout_modelDir(t *testing.T) {
+	cases := []struct {
+		layout    Layout
+		modelType string
+		expect    string
+	}{
+		{layout: LayoutAutomatic1111, modelType: ModelTypeCheckpoint, expect: filepath.Join("models", "Stable-diffusion")},
+		{layout: LayoutAutomatic1111, modelType: "lora", expect: filepath.Join("models", "Lora")},
+		{layout: LayoutComfyUI, modelType: ModelTypeLoCon, expect: filepath.Join("models", "loras")},
+		{layout: LayoutComfyUI, modelType: ModelTypeUpscaler, expect: filepath.Join("models", "upscale_models")},
+		{layout: LayoutInvokeAI, modelType: ModelTypeHypernetwork},
+		{layout: LayoutAutomatic1111, modelType: "Wildcards"},
+	}
+	for _, c := range cases {
+		t.Run(c.layout.Name+"/"+c.modelType, func(t *testing.T) {
+			res, ok := c.layout.modelDir(c.modelType)
+			if ok != (c.expect != "") || res != c.expect {
+				t.Errorf("expect %q, got %q", c.expect, res)
+			}
+		})
+	}
+}
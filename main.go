@@ -15,10 +15,14 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2/terminal"
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 )
 
 const (
@@ -29,13 +33,14 @@ const (
 // ErrUnknownFormat returns if the given format is neither safetensor nor pickle.
 var ErrUnknownFormat = fmt.Errorf("unknown format is specified")
 
-var defaultTargets = []string{
-	filepath.Join("models", "hypernetworks"),
-	filepath.Join("models", "Lora"),
-	filepath.Join("models", "Stable-diffusion"),
-	filepath.Join("models", "VAE"),
-	"embeddings",
-}
+// ErrUnknownPolicy returns if the given policy is not one of ask, latest, all, and none.
+var ErrUnknownPolicy = fmt.Errorf("unknown policy is specified")
+
+// ErrUnknownOldVersionMode returns if the given mode is not one of keep, delete, and archive.
+var ErrUnknownOldVersionMode = fmt.Errorf("unknown mode for old versions is specified")
+
+// ErrUnknownOutput returns if the given output format is neither text nor json.
+var ErrUnknownOutput = fmt.Errorf("unknown output format is specified")
 
 var modelFileExtensions = []string{".safetensors", ".ckpt", ".pt"}
 
@@ -50,72 +55,524 @@ func isModelFile(name string) bool {
 	return false
 }
 
-func run(ctx context.Context) error {
-	preferredFormat := SafetensorFormat
-	flag.Func(
-		"format",
-		fmt.Sprintf("prefered file format: %v or %v (default %v)", SafetensorFormat, PickleFormat, SafetensorFormat),
+// openHashCache loads the hash cache from the user cache directory and the cache of the web UI
+// in the given root directory.
+// If rehash is true or the stored cache is broken, it starts with an empty cache
+// that will overwrite the stored one. It always returns a usable cache even if it also returns an error.
+func openHashCache(root string, rehash bool) (*hashCache, error) {
+	name, err := defaultHashCachePath()
+	if err != nil {
+		return newHashCache(""), err
+	}
+	if rehash {
+		return newHashCache(name), nil
+	}
+
+	c, err := loadHashCache(name)
+	if err != nil {
+		c = newHashCache(name)
+	}
+
+	webUI, e := loadWebUICache(filepath.Join(root, webUICacheFile))
+	if e != nil && !errors.Is(e, os.ErrNotExist) {
+		err = errors.Join(err, e)
+	}
+	c.webUI = webUI
+	return c, err
+}
+
+func parseFormat(s string) (string, error) {
+	if s != SafetensorFormat && s != PickleFormat {
+		return "", ErrUnknownFormat
+	}
+	return s, nil
+}
+
+func parsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyAsk, PolicyLatest, PolicyAll, PolicyNone:
+		return p, nil
+	default:
+		return "", ErrUnknownPolicy
+	}
+}
+
+func parseOldVersionMode(s string) (OldVersionMode, error) {
+	switch m := OldVersionMode(s); m {
+	case OldVersionKeep, OldVersionDelete, OldVersionArchive:
+		return m, nil
+	default:
+		return "", ErrUnknownOldVersionMode
+	}
+}
+
+// isInteractive returns true if stdin is a terminal.
+func isInteractive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
+
+// sharedFlags are command-line flags shared by all commands.
+type sharedFlags struct {
+	fs     *flag.FlagSet
+	format string
+	policy Policy
+	yes    bool
+	token  string
+	layout *Layout
+	config string
+	apiURL string
+	http   HTTPOptions
+	// rateLimit is the maximum number of requests per second to the Civitai API.
+	rateLimit float64
+	cacheTTL  time.Duration
+	// batchPolicy is the default policy if stdin is not a terminal.
+	batchPolicy Policy
+	// rehash and jobs are defined by addHashFlags.
+	rehash bool
+	jobs   int
+}
+
+// newSharedFlags defines the shared flags in the given flag set.
+func newSharedFlags(fs *flag.FlagSet) *sharedFlags {
+	f := &sharedFlags{fs: fs, format: SafetensorFormat, jobs: 1}
+	fs.StringVar(&f.token, "token", "", "Civitai API token to download models requiring authentication "+
+		"(default $CIVITAI_API_TOKEN)")
+	fs.Func(
+		"layout",
+		fmt.Sprintf("layout of the web UI: %v (default detected from files in the current directory)",
+			strings.Join(layoutNames(), ", ")),
 		func(s string) error {
-			if s != SafetensorFormat && s != PickleFormat {
-				return ErrUnknownFormat
+			l, err := parseLayout(s)
+			if err != nil {
+				return err
 			}
-			preferredFormat = s
+			f.layout = &l
 			return nil
 		},
 	)
+	fs.StringVar(&f.config, "config", "",
+		fmt.Sprintf("config file (default ./%v or %v in the user config directory)", configFile,
+			filepath.Join("sd-model-updater", "config.yaml")))
+	fs.StringVar(&f.apiURL, "api-url", "", "base URL of the Civitai API (default https://civitai.com/api/v1)")
+	fs.StringVar(&f.http.Proxy, "proxy", "", "URL of an HTTP(S) proxy (default $HTTPS_PROXY or $HTTP_PROXY)")
+	fs.DurationVar(&f.http.Timeout, "timeout", 0,
+		"timeout to connect to servers and wait for their responses; downloads are not limited (default no timeout)")
+	fs.StringVar(&f.http.CABundle, "ca-bundle", "", "PEM file of CA certificates to trust in addition to the system ones")
+	fs.StringVar(&f.http.UserAgent, "user-agent", "",
+		fmt.Sprintf("User-Agent header sent with requests (default %v)", defaultUserAgent))
+	fs.IntVar(&f.http.Attempts, "attempts", defaultAttempts,
+		"maximum number of attempts of each request failed with 429, 5xx, or a network error")
+	fs.Float64Var(&f.rateLimit, "rate-limit", defaultRateLimit,
+		"maximum number of requests per second to the Civitai API; 0 means no limit")
+	fs.DurationVar(&f.cacheTTL, "cache-ttl", defaultCacheTTL,
+		"time to use cached responses of the Civitai API without asking the server; 0 disables the cache")
+	return f
+}
 
-	flag.Parse()
-	targets := flag.Args()
-	if len(targets) == 0 {
-		wd, err := os.Getwd()
-		if err != nil {
+// addDownloadFlags defines flags of commands choosing versions to download.
+// The given policy is the default if stdin is not a terminal.
+func (f *sharedFlags) addDownloadFlags(batchPolicy Policy) {
+	f.batchPolicy = batchPolicy
+	f.fs.Func(
+		"format",
+		fmt.Sprintf("prefered file format: %v or %v (default %v)", SafetensorFormat, PickleFormat, SafetensorFormat),
+		func(s string) (err error) {
+			f.format, err = parseFormat(s)
 			return err
+		},
+	)
+	f.fs.Func(
+		"policy",
+		fmt.Sprintf(
+			"which versions to download: %v, %v, %v, or %v (default %v if stdin is a terminal, otherwise %v)",
+			PolicyAsk, PolicyLatest, PolicyAll, PolicyNone, PolicyAsk, batchPolicy),
+		func(s string) (err error) {
+			f.policy, err = parsePolicy(s)
+			return err
+		},
+	)
+	f.fs.BoolVar(&f.yes, "yes", false,
+		fmt.Sprintf("download the newest version without asking (same as -policy %v)", PolicyLatest))
+}
+
+// addHashFlags defines flags of commands hashing model files. If jobs is true, it also defines -jobs for commands
+// hashing files concurrently.
+func (f *sharedFlags) addHashFlags(jobs bool) {
+	f.fs.BoolVar(&f.rehash, "rehash", false, "ignore cached hashes and recompute hashes of all model files")
+	if jobs {
+		f.fs.IntVar(&f.jobs, "jobs", 1, "number of model files to hash and look up concurrently")
+	}
+}
+
+// settings are the values of the shared flags merged with the config file.
+type settings struct {
+	// Root is the root directory of the web UI, which is the current directory.
+	Root   string
+	Config *Config
+	Layout Layout
+	Format string
+	// Policy is the policy given by the flags. It is empty if no flags specify it.
+	Policy Policy
+	// DefaultPolicy is used if neither the flags nor the config file specify a policy.
+	DefaultPolicy Policy
+	Token         string
+	// APIURL is the base URL of the Civitai API. It is empty if neither the flags nor the config file specify it.
+	APIURL string
+	// HTTPClient is the HTTP client configured by the flags and the config file.
+	HTTPClient *http.Client
+	// RateLimit is the maximum number of requests per second to the Civitai API. Zero means no limit.
+	RateLimit float64
+	// CacheTTL is the time to use cached responses of the Civitai API. Zero disables the cache.
+	CacheTTL time.Duration
+	// Rehash ignores cached hashes, and Jobs is the number of model files to hash and look up concurrently.
+	Rehash bool
+	Jobs   int
+	// Set records names of the flags given explicitly.
+	Set map[string]bool
+}
+
+// settings loads the config file and merges it with the parsed flags; the flags override values in the config file.
+func (f *sharedFlags) settings() (*settings, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := loadConfig(f.config, wd)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &settings{
+		Root:      wd,
+		Config:    cfg,
+		Format:    f.format,
+		Policy:    f.policy,
+		Token:     f.token,
+		APIURL:    f.apiURL,
+		RateLimit: f.rateLimit,
+		CacheTTL:  f.cacheTTL,
+		Rehash:    f.rehash,
+		Jobs:      f.jobs,
+		Set:       make(map[string]bool),
+	}
+	f.fs.Visit(func(f *flag.Flag) {
+		res.Set[f.Name] = true
+	})
+
+	switch {
+	case f.layout != nil:
+		res.Layout = *f.layout
+	case cfg.Layout != "":
+		// the config has been validated.
+		res.Layout, _ = parseLayout(cfg.Layout)
+	default:
+		res.Layout = detectLayout(wd)
+	}
+	if !res.Set["format"] && cfg.Format != "" {
+		res.Format = cfg.Format
+	}
+	if !res.Set["token"] {
+		if env := os.Getenv("CIVITAI_API_TOKEN"); env != "" {
+			res.Token = env
+		} else {
+			res.Token = cfg.Token
 		}
-		for _, t := range defaultTargets {
-			targets = append(targets, filepath.Join(wd, t))
+	}
+	httpOpts := f.http
+	if !res.Set["api-url"] {
+		res.APIURL = cfg.APIURL
+	}
+	if !res.Set["proxy"] {
+		httpOpts.Proxy = cfg.Proxy
+	}
+	if !res.Set["timeout"] {
+		httpOpts.Timeout = cfg.Timeout
+	}
+	if !res.Set["ca-bundle"] {
+		httpOpts.CABundle = cfg.CABundle
+	}
+	if !res.Set["user-agent"] {
+		httpOpts.UserAgent = cfg.UserAgent
+	}
+	if !res.Set["attempts"] && cfg.Attempts != 0 {
+		httpOpts.Attempts = cfg.Attempts
+	}
+	if res.HTTPClient, err = newHTTPClient(httpOpts); err != nil {
+		return nil, err
+	}
+	if !res.Set["rate-limit"] && cfg.RateLimit != nil {
+		res.RateLimit = *cfg.RateLimit
+	}
+	if !res.Set["cache-ttl"] && cfg.CacheTTL != nil {
+		res.CacheTTL = *cfg.CacheTTL
+	}
+	if !res.Set["jobs"] && cfg.Jobs != 0 {
+		res.Jobs = cfg.Jobs
+	}
+
+	if f.yes && res.Policy == "" {
+		res.Policy = PolicyLatest
+	}
+	if isInteractive() {
+		res.DefaultPolicy = PolicyAsk
+	} else {
+		res.DefaultPolicy = f.batchPolicy
+	}
+	return res, nil
+}
+
+// openHashCache opens the hash cache for the root directory, and returns it with a function saving it.
+// Failures to load or save the cache are printed to stderr as warnings since the cache only saves time.
+func (s *settings) openHashCache() (*hashCache, func()) {
+	hashes, err := openHashCache(s.Root, s.Rehash)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.YellowString("Failed to load cached hashes: %v", err))
+	}
+	return hashes, func() {
+		if err := hashes.save(); err != nil {
+			fmt.Fprintln(os.Stderr, color.YellowString("Failed to save the hash cache: %v", err))
+		}
+	}
+}
+
+// newClient creates a Civitai client configured by the settings.
+func (s *settings) newClient() (Client, error) {
+	cli := NewClient(s.Format).WithToken(s.Token).WithRateLimit(s.RateLimit)
+	if dir, err := defaultResponseCacheDir(); err == nil {
+		cli = cli.WithCache(dir, s.CacheTTL)
+	}
+	return cli.WithHTTPClient(s.HTTPClient, s.APIURL)
+}
+
+// newHuggingFace creates a Hugging Face source configured by the settings and the HF_TOKEN environment variable.
+func (s *settings) newHuggingFace() HuggingFace {
+	return NewHuggingFace().WithHTTPClient(s.HTTPClient).WithToken(os.Getenv("HF_TOKEN"))
+}
+
+// targets returns the given paths as targets, or the targets in the config file or the layout if no paths are given.
+// The given policy overrides policies in the config file unless it is empty. Targets of the layout that don't exist
+// are omitted, while the other targets are returned even if they don't exist, so that the caller reports them.
+func (s *settings) targets(paths []string, policy Policy) []target {
+	cfg := s.Config
+	newTarget := func(t TargetConfig) target {
+		if !filepath.IsAbs(t.Path) {
+			t.Path = filepath.Join(s.Root, t.Path)
+		}
+		if s.Set["format"] {
+			t.Format = s.Format
+		}
+		return target{
+			Path:    t.Path,
+			Format:  firstNonEmpty(t.Format, s.Format),
+			Policy:  firstNonEmpty(policy, t.Policy, cfg.Policy, s.DefaultPolicy),
+			Exclude: append(cfg.Exclude[:len(cfg.Exclude):len(cfg.Exclude)], t.Exclude...),
+		}
+	}
+
+	var res []target
+	switch {
+	case len(paths) != 0:
+		for _, name := range paths {
+			res = append(res, newTarget(TargetConfig{Path: name}))
+		}
+	case len(cfg.Targets) != 0:
+		for _, t := range cfg.Targets {
+			res = append(res, newTarget(t))
+		}
+	default:
+		// the layout lists every directory the web UI may have, so directories that don't exist are skipped.
+		for _, p := range s.Layout.Targets {
+			t := newTarget(TargetConfig{Path: p})
+			if _, err := os.Stat(t.Path); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// command is a subcommand.
+type command struct {
+	Name        string
+	Description string
+}
+
+// commands is a list of subcommands shown in the usage. If no commands are given, update runs.
+var commands = []command{
+	{Name: "update", Description: "check for and download newer versions of installed models"},
+	{Name: "install", Description: "download a model from a Civitai URL or ID"},
+	{Name: "export", Description: "write a manifest of installed models"},
+	{Name: "sync", Description: "download models in a manifest that are missing or modified"},
+	{Name: "fetch-metadata", Description: "write preview images and information of installed models"},
+}
+
+// newFlagSet creates a flag set of the given command with the given synopsis.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet("sd-model-updater "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		_, _ = fmt.Fprintf(w, "Usage: sd-model-updater %v\n\nCommands:\n", synopsis)
+		for _, c := range commands {
+			_, _ = fmt.Fprintf(w, "  %-16v %v\n", c.Name, c.Description)
 		}
+		_, _ = fmt.Fprintln(w, "\nFlags:")
+		fs.PrintDefaults()
 	}
+	return fs
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		switch args[0] {
+		case "update":
+			return runUpdate(ctx, args[1:])
+		case "install":
+			return runInstall(ctx, args[1:])
+		case "export":
+			return runExport(ctx, args[1:])
+		case "sync":
+			return runSync(ctx, args[1:])
+		case "fetch-metadata":
+			return runFetchMetadata(ctx, args[1:])
+		}
+	}
+	return runUpdate(ctx, args)
+}
 
-	cli := NewClient(preferredFormat)
-	for _, name := range targets {
-		stat, err := os.Stat(name)
+// runUpdate checks models in the given files and directories for updates.
+func runUpdate(ctx context.Context, args []string) error {
+	fs := newFlagSet("update", "[update] [flags] [path ...]")
+	shared := newSharedFlags(fs)
+	shared.addDownloadFlags(PolicyNone)
+	shared.addHashFlags(true)
+	old := OldVersionKeep
+	fs.Func(
+		"old",
+		fmt.Sprintf("what to do with old versions after newer versions are downloaded: %v, %v, or %v (default %v)",
+			OldVersionKeep, OldVersionDelete, OldVersionArchive, OldVersionKeep),
+		func(s string) (err error) {
+			old, err = parseOldVersionMode(s)
+			return err
+		},
+	)
+	archiveDir := fs.String("archive-dir", "archive", "directory to move old versions into with -old archive")
+	check := fs.Bool("check", false, "check for updates without downloading any models")
+	anyBaseModel := fs.Bool("any-base-model", false,
+		"also offer newer versions whose base models differ from the current version's")
+	output := TextOutput
+	fs.Func(
+		"output",
+		fmt.Sprintf("output format: %v or %v; %v implies -check and writes a report to stdout (default %v)",
+			TextOutput, JSONOutput, JSONOutput, TextOutput),
+		func(s string) error {
+			if s != TextOutput && s != JSONOutput {
+				return ErrUnknownOutput
+			}
+			output = s
+			return nil
+		},
+	)
+	_ = fs.Parse(args)
+
+	s, err := shared.settings()
+	if err != nil {
+		return err
+	}
+	wd, cfg := s.Root, s.Config
+	if !s.Set["old"] && cfg.Old != "" {
+		old = cfg.Old
+	}
+	if !s.Set["archive-dir"] && cfg.ArchiveDir != "" {
+		*archiveDir = cfg.ArchiveDir
+	}
+	if !s.Set["any-base-model"] {
+		*anyBaseModel = cfg.AnyBaseModel
+	}
+
+	policy := s.Policy
+	if *check {
+		policy = PolicyNone
+	}
+
+	targets := s.targets(fs.Args(), policy)
+
+	hashes, closeHashes := s.openHashCache()
+	defer closeHashes()
+
+	cli, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	sources := []Source{s.newHuggingFace().WithHashCache(hashes)}
+	if output == JSONOutput {
+		return checkTargets(ctx, cli, hashes, targets, scanOptions{
+			Jobs:         s.Jobs,
+			AnyBaseModel: *anyBaseModel,
+			Sources:      sources,
+		}, os.Stdout)
+	}
+
+	for _, t := range targets {
+		stat, err := os.Stat(t.Path)
 		if err != nil {
 			return err
 		}
 
+		cli.PreferredFormat = t.Format
+		opts := updateOptions{
+			Policy:     t.Policy,
+			Old:        old,
+			ArchiveDir: *archiveDir,
+			Root:       wd,
+			Layout:     s.Layout,
+		}
 		if !stat.IsDir() {
-			update, err := findUpdate(ctx, cli, name)
+			update, err := findUpdate(ctx, cli, hashes, t.Path, scanOptions{
+				AnyBaseModel: *anyBaseModel,
+				Sources:      sources,
+			})
 			if err != nil {
-				if coder, ok := err.(interface {
-					Code() int
-				}); ok {
-					if coder.Code() == http.StatusNotFound {
-						fmt.Println(color.YellowString("Model information is not found"))
-						continue
-					}
+				if isNotFound(err) {
+					fmt.Println(color.YellowString("Model information is not found"))
+					continue
 				}
-				fmt.Println(color.RedString("Failed to find updates to %v: %v", filepath.Base(name), err))
+				fmt.Println(color.RedString("Failed to find updates to %v: %v", filepath.Base(t.Path), err))
 				continue
 			}
 
-			err = update.run(ctx, cli, filepath.Dir(name))
+			err = update.run(ctx, cli, filepath.Dir(t.Path), opts)
 			if err != nil {
 				if errors.Is(err, terminal.InterruptErr) {
 					return err
 				}
-				fmt.Println(color.RedString("Failed to update %v: %v", filepath.Base(name), err))
+				fmt.Println(color.RedString("Failed to update %v: %v", filepath.Base(t.Path), err))
 			}
 		} else {
-			fmt.Println("Retrieving models in", name)
+			fmt.Println("Retrieving models in", t.Path)
 
-			updates, err := findUpdatesFromDir(ctx, cli, name)
+			updates, unknowns, err := findUpdatesFromDir(ctx, cli, hashes, t.Path, scanOptions{
+				Jobs:         s.Jobs,
+				Exclude:      t.Exclude,
+				AnyBaseModel: *anyBaseModel,
+				Sources:      sources,
+			})
 			if err != nil {
-				fmt.Println(color.RedString("Failed to find updates to models in %v: %v", name, err))
+				fmt.Println(color.RedString("Failed to find updates to models in %v: %v", t.Path, err))
 				continue
 			}
+			for _, f := range unknowns {
+				printUnknown(f)
+			}
 
 			for _, u := range updates {
-				err = u.run(ctx, cli, name)
+				// models without candidates are still reported if newer versions for other base models are skipped,
+				// so users know about -any-base-model.
+				if len(u.Candidates) == 0 && u.Incompatible == 0 {
+					continue
+				}
+				err = u.run(ctx, cli, t.Path, opts)
 				if err != nil {
 					if errors.Is(err, terminal.InterruptErr) {
 						return err
@@ -130,9 +587,18 @@ func run(ctx context.Context) error {
 }
 
 func main() {
-	err := run(context.Background())
+	// Ctrl-C cancels the context so that downloads clean up their partial files.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		// a second Ctrl-C terminates the process immediately.
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := run(ctx, os.Args[1:])
+	stop()
 	if err != nil {
-		fmt.Println(color.RedString("Failed to check for updates: %v", err))
+		fmt.Println(color.RedString("Failed: %v", err))
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,94 @@
+// main_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_settings_targets(t *testing.T) {
+	root := t.TempDir()
+	lora := filepath.Join(root, "models", "Lora")
+	if err := os.MkdirAll(lora, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := &settings{
+		Root:   root,
+		Config: new(Config),
+		Layout: LayoutAutomatic1111,
+		Set:    make(map[string]bool),
+	}
+
+	cases := []struct {
+		name   string
+		paths  []string
+		config []TargetConfig
+		expect []string
+	}{
+		{name: "layout", expect: []string{lora}},
+		{name: "paths", paths: []string{"models/hypernetworks"}, expect: []string{
+			filepath.Join(root, "models", "hypernetworks"),
+		}},
+		{name: "config", config: []TargetConfig{{Path: "models/hypernetworks"}}, expect: []string{
+			filepath.Join(root, "models", "hypernetworks"),
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s.Config.Targets = c.config
+			res := s.targets(c.paths, "")
+			if len(res) != len(c.expect) {
+				t.Fatalf("expect %v, got %+v", c.expect, res)
+			}
+			for i, p := range c.expect {
+				if res[i].Path != p {
+					t.Errorf("expect %v, got %v", p, res[i].Path)
+				}
+			}
+		})
+	}
+}
+
+func Test_sharedFlags_addHashFlags(t *testing.T) {
+	config := filepath.Join(t.TempDir(), configFile)
+	if err := os.WriteFile(config, []byte("jobs: 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		args   []string
+		rehash bool
+		expect int
+	}{
+		{name: "config", expect: 4},
+		{name: "flags", args: []string{"-jobs", "2", "-rehash"}, rehash: true, expect: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			shared := newSharedFlags(fs)
+			shared.addHashFlags(true)
+			if err := fs.Parse(append([]string{"-config", config}, c.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := shared.settings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Jobs != c.expect || s.Rehash != c.rehash {
+				t.Errorf("expect jobs %v and rehash %v, got %v and %v", c.expect, c.rehash, s.Jobs, s.Rehash)
+			}
+		})
+	}
+}
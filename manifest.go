@@ -0,0 +1,350 @@
+// manifest.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/fatih/color"
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+// manifestFile is the default name of the manifest in the root directory of the web UI.
+const manifestFile = "sd-model-updater.lock.json"
+
+// syncDir is the directory files are downloaded into before they replace mismatched files.
+// It is created in the directory of each file and removed after the file is synced.
+const syncDir = ".sd-model-updater"
+
+var (
+	// ErrSyncFailed returns if some files in the manifest couldn't be synced.
+	ErrSyncFailed = errors.New("failed to sync some files")
+	// ErrPathNotLocal returns if a path in the manifest is absolute or escapes the root directory of the web UI.
+	ErrPathNotLocal = errors.New("path is outside the root directory")
+)
+
+// Manifest describes the exact set of model files installed in a web UI.
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is a model file in a manifest.
+type ManifestEntry struct {
+	ModelID   int64 `json:"modelId"`
+	VersionID int64 `json:"versionId"`
+	// Name is the name of the file on Civitai.
+	Name   string `json:"name"`
+	BLAKE3 string `json:"blake3"`
+	// Path is the slash-separated path to the file relative to the root directory of the web UI.
+	Path string `json:"path"`
+}
+
+// manifestPath returns the path to the given file stored in a manifest.
+// It returns false if the file is outside the given root directory since the path wouldn't exist on other machines.
+func manifestPath(root, name string) (string, bool) {
+	rel, err := filepath.Rel(root, name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// add adds entries of the local files of the given update. It returns files whose versions are not identified.
+func (m *Manifest) add(hashes *hashCache, root string, u *Update) ([]LocalFile, error) {
+	var unknowns []LocalFile
+	for _, f := range u.Files {
+		var file *models.File
+		if f.Version != nil {
+			_, file = findVersionFile([]*models.ModelVersion{f.Version}, f.Hash)
+		}
+		if file == nil {
+			unknowns = append(unknowns, f)
+			continue
+		}
+
+		p, ok := manifestPath(root, f.Path)
+		if !ok {
+			fmt.Println(color.YellowString("%v is outside the root directory and not exported", f.Path))
+			continue
+		}
+
+		hash := strings.ToLower(file.Hashes.BLAKE3)
+		if hash == "" {
+			// the file has been found by SHA256, so its BLAKE3 hash must be computed.
+			var err error
+			if hash, err = hashes.fileHash(f.Path, nil); err != nil {
+				return nil, err
+			}
+		}
+		m.Files = append(m.Files, ManifestEntry{
+			ModelID:   u.ModelID,
+			VersionID: f.Version.ID,
+			Name:      file.Name,
+			BLAKE3:    hash,
+			Path:      p,
+		})
+	}
+	return unknowns, nil
+}
+
+// write writes the manifest in JSON sorted by file paths.
+func (m *Manifest) write(w io.Writer) error {
+	if m.Files == nil {
+		m.Files = []ManifestEntry{}
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// readManifest reads the named manifest.
+func readManifest(name string) (*Manifest, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(Manifest)
+	if err = json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", name, err)
+	}
+	return res, nil
+}
+
+// exportManifest creates a manifest of model files identified in the given targets.
+func exportManifest(
+	ctx context.Context, cli Client, hashes *hashCache, root string, targets []target, jobs int,
+) (*Manifest, error) {
+	res := new(Manifest)
+	for _, t := range targets {
+		stat, err := os.Stat(t.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var updates []*Update
+		var unknowns []LocalFile
+		if !stat.IsDir() {
+			u, err := findUpdate(ctx, cli, hashes, t.Path, scanOptions{})
+			if err != nil {
+				if !isNotFound(err) {
+					return nil, err
+				}
+				unknowns = append(unknowns, LocalFile{Path: t.Path})
+			} else {
+				updates = append(updates, u)
+			}
+		} else {
+			fmt.Println("Retrieving models in", t.Path)
+			updates, unknowns, err = findUpdatesFromDir(ctx, cli, hashes, t.Path, scanOptions{
+				Jobs:    jobs,
+				Exclude: t.Exclude,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, u := range updates {
+			files, err := res.add(hashes, root, u)
+			if err != nil {
+				return nil, err
+			}
+			unknowns = append(unknowns, files...)
+		}
+		for _, f := range unknowns {
+			printUnknown(f)
+		}
+	}
+	return res, nil
+}
+
+// syncManifest downloads files in the manifest that are missing or whose hashes don't match.
+// Paths in the manifest are relative to the given root directory.
+func syncManifest(ctx context.Context, cli Client, hashes *hashCache, root string, m *Manifest) error {
+	ms := make(map[int64]*models.Model)
+	failed := 0
+	for _, e := range m.Files {
+		err := syncEntry(ctx, cli, hashes, root, e, ms)
+		if err != nil {
+			if errors.Is(err, terminal.InterruptErr) || ctx.Err() != nil {
+				return err
+			}
+			fmt.Println(color.RedString("Failed to sync %v: %v", e.Path, err))
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%w: %v of %v files", ErrSyncFailed, failed, len(m.Files))
+	}
+	return nil
+}
+
+// syncEntry downloads the file of the given entry if it is missing or its hash doesn't match.
+// Models retrieved from Civitai are cached in the given map. It refuses entries whose paths are outside the root
+// directory so that a shared manifest cannot write files anywhere else.
+func syncEntry(
+	ctx context.Context, cli Client, hashes *hashCache, root string, e ManifestEntry, ms map[int64]*models.Model,
+) error {
+	dest := filepath.FromSlash(e.Path)
+	if !filepath.IsLocal(dest) {
+		return fmt.Errorf("%w: %v", ErrPathNotLocal, e.Path)
+	}
+	dest = filepath.Join(root, dest)
+	if _, err := os.Stat(dest); err == nil {
+		hash, err := hashes.fileHash(dest, nil)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(hash, e.BLAKE3) {
+			fmt.Println(e.Path, "is up to date")
+			return nil
+		}
+		fmt.Println(color.YellowString("%v doesn't match the manifest", e.Path))
+	}
+
+	m, ok := ms[e.ModelID]
+	if !ok {
+		var err error
+		if m, err = cli.GetModel(ctx, e.ModelID); err != nil {
+			return err
+		}
+		ms[e.ModelID] = m
+	}
+
+	var ver *models.ModelVersion
+	for _, v := range m.ModelVersions {
+		if v.ID == e.VersionID {
+			ver = v
+		}
+	}
+	if ver == nil {
+		return fmt.Errorf("%w: version %v of %v", ErrModelNotFound, e.VersionID, m.Name)
+	}
+	_, file := findVersionFile([]*models.ModelVersion{ver}, e.BLAKE3)
+	if file == nil {
+		return fmt.Errorf("%w: %v", ErrFileNotFound, e.Name)
+	}
+
+	// download only the file in the manifest regardless of the preferred format.
+	f := *file
+	f.Primary = true
+	v := *ver
+	v.Files = []*models.File{&f}
+
+	dir := filepath.Join(filepath.Dir(dest), syncDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name, err := cli.Download(ctx, &v, dir)
+	if err != nil {
+		return err
+	}
+	// the model has been downloaded even if its metadata cannot be written.
+	if err = cli.WriteMetadata(ctx, m, &v, name); err != nil {
+		fmt.Println(color.YellowString("Failed to write metadata of %v: %v", filepath.Base(name), err))
+	}
+	if err = os.Rename(name, dest); err != nil {
+		return err
+	}
+	for _, ext := range sidecarExts {
+		if _, err = os.Stat(sidecarPath(name, ext)); err == nil {
+			if err = os.Rename(sidecarPath(name, ext), sidecarPath(dest, ext)); err != nil {
+				return err
+			}
+		}
+	}
+	// the directory may have partial files of other downloads.
+	_ = os.Remove(dir)
+	fmt.Println(color.GreenString("Synced %v", e.Path))
+	return nil
+}
+
+// runExport writes a manifest of model files in the given files and directories.
+func runExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("export", "export [flags] [path ...]")
+	shared := newSharedFlags(fs)
+	shared.addHashFlags(true)
+	output := fs.String("o", manifestFile, "file to write the manifest to")
+	_ = fs.Parse(args)
+
+	s, err := shared.settings()
+	if err != nil {
+		return err
+	}
+
+	hashes, closeHashes := s.openHashCache()
+	defer closeHashes()
+
+	cli, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	m, err := exportManifest(ctx, cli, hashes, s.Root, s.targets(fs.Args(), PolicyNone), s.Jobs)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err = m.write(f); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	fmt.Println(color.GreenString("Wrote %v files to %v", len(m.Files), *output))
+	return nil
+}
+
+// runSync downloads files in the given manifest that are missing or modified.
+func runSync(ctx context.Context, args []string) error {
+	fs := newFlagSet("sync", "sync [flags] [manifest]")
+	shared := newSharedFlags(fs)
+	shared.addHashFlags(false)
+	_ = fs.Parse(args)
+
+	s, err := shared.settings()
+	if err != nil {
+		return err
+	}
+
+	name := manifestFile
+	if fs.NArg() != 0 {
+		name = fs.Arg(0)
+	}
+	m, err := readManifest(name)
+	if err != nil {
+		return err
+	}
+
+	hashes, closeHashes := s.openHashCache()
+	defer closeHashes()
+
+	cli, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	return syncManifest(ctx, cli, hashes, s.Root, m)
+}
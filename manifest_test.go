@@ -0,0 +1,247 @@
+// manifest_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jkawamoto/go-civitai/models"
+	"github.com/zeebo/blake3"
+)
+
+func Test_manifest(t *testing.T) {
+	ctx := context.Background()
+	const modelID = 100
+
+	server, versions := newVersionServer(t, "v1", "v2")
+	service := &fakeService{models: []*models.Model{{
+		ID:            modelID,
+		Name:          "model",
+		Type:          ModelTypeLORA,
+		ModelVersions: []*models.ModelVersion{versions["v1"], versions["v2"]},
+	}}}
+	cli := service.newClient(t)
+	cli.httpClient = server.Client()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "models", "Lora")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"v1", "unknown"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".safetensors"), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := exportManifest(ctx, cli, nil, root, []target{{Path: dir}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []ManifestEntry{{
+		ModelID:   modelID,
+		VersionID: versions["v1"].ID,
+		Name:      "v1.safetensors",
+		BLAKE3:    strings.ToLower(versions["v1"].Files[0].Hashes.BLAKE3),
+		Path:      "models/Lora/v1.safetensors",
+	}}
+	if !reflect.DeepEqual(m.Files, expect) {
+		t.Fatalf("expect %+v, got %+v", expect, m.Files)
+	}
+
+	name := filepath.Join(root, manifestFile)
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m, err = readManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Files, expect) {
+		t.Fatalf("expect %+v, got %+v", expect, m.Files)
+	}
+
+	renamed := expect[0]
+	renamed.Path = "models/Lora/renamed.safetensors"
+	cases := []struct {
+		name    string
+		entry   ManifestEntry
+		content string
+		err     error
+	}{
+		{name: "missing", entry: expect[0]},
+		{name: "mismatched", entry: expect[0], content: "broken"},
+		{name: "up to date", entry: expect[0], content: "v1"},
+		{name: "renamed", entry: renamed},
+		{
+			name:  "unknown version",
+			entry: ManifestEntry{ModelID: modelID, VersionID: 1000, Path: "a.safetensors"},
+			err:   ErrSyncFailed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, filepath.FromSlash(c.entry.Path))
+			if c.content != "" {
+				if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(dest, []byte(c.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := syncManifest(ctx, cli, nil, root, &Manifest{Files: []ManifestEntry{c.entry}})
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("expect %v, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if h := modelHash(t, dest); h != c.entry.BLAKE3 {
+				t.Errorf("expect %v, got %v", c.entry.BLAKE3, h)
+			}
+			if _, err = os.Stat(filepath.Join(filepath.Dir(dest), syncDir)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expect the sync directory is removed, got %v", err)
+			}
+		})
+	}
+}
+
+// Test_manifest_api checks the manifest with a stand-in of the Civitai API, whose by-hash endpoint returns a version
+// with different id and modelId as the real API does.
+func Test_manifest_api(t *testing.T) {
+	ctx := context.Background()
+	const (
+		modelID   = 1234
+		versionID = 5678
+	)
+	sum := blake3.Sum256([]byte("v1"))
+	hash := hex.EncodeToString(sum[:])
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	serveJSON := func(path, body string) {
+		mux.HandleFunc("/api/v1"+path, func(res http.ResponseWriter, _ *http.Request) {
+			res.Header().Set("Content-Type", "application/json")
+			_, _ = res.Write([]byte(body))
+		})
+	}
+	version := fmt.Sprintf(`{"id": %d, "modelId": %d, "name": "v1", "files": [{"name": "v1.safetensors", `+
+		`"primary": true, "downloadUrl": "%v/download/v1", "hashes": {"BLAKE3": "%v"}}]}`,
+		versionID, modelID, server.URL, hash)
+	serveJSON("/model-versions/by-hash/"+hash, version)
+	serveJSON(fmt.Sprintf("/model-versions/%d", versionID), version)
+	serveJSON(fmt.Sprintf("/models/%d", modelID),
+		fmt.Sprintf(`{"id": %d, "name": "model", "type": "LORA", "modelVersions": [%v]}`, modelID, version))
+	// a model whose ID is the version ID is unrelated to the version.
+	serveJSON(fmt.Sprintf("/models/%d", versionID),
+		fmt.Sprintf(`{"id": %d, "name": "other", "type": "LORA", "modelVersions": []}`, versionID))
+	mux.HandleFunc("/download/v1", func(res http.ResponseWriter, _ *http.Request) {
+		res.Header().Set("Content-Disposition", "attachment; filename=v1.safetensors;")
+		_, _ = res.Write([]byte("v1"))
+	})
+
+	cli, err := NewClient(SafetensorFormat).WithHTTPClient(server.Client(), server.URL+"/api/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "models", "Lora")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "v1.safetensors")
+	if err = os.WriteFile(name, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := exportManifest(ctx, cli, nil, root, []target{{Path: dir}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].ModelID != modelID || m.Files[0].VersionID != versionID {
+		t.Fatalf("expect model %v and version %v, got %+v", modelID, versionID, m.Files)
+	}
+
+	if err = os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err = syncManifest(ctx, cli, nil, root, m); err != nil {
+		t.Fatal(err)
+	}
+	if h := modelHash(t, name); h != hash {
+		t.Errorf("expect %v, got %v", hash, h)
+	}
+}
+
+func Test_manifestPath(t *testing.T) {
+	root := t.TempDir()
+	cases := []struct {
+		name   string
+		expect string
+		ok     bool
+	}{
+		{name: filepath.Join(root, "models", "Lora", "a.safetensors"), expect: "models/Lora/a.safetensors", ok: true},
+		{name: filepath.Join(filepath.Dir(root), "a.safetensors")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, ok := manifestPath(root, c.name)
+			if res != c.expect || ok != c.ok {
+				t.Errorf("expect %q and %v, got %q and %v", c.expect, c.ok, res, ok)
+			}
+		})
+	}
+}
+
+func Test_syncEntry_notLocal(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(filepath.Dir(root), "x.safetensors")
+
+	for _, p := range []string{"../x.safetensors", filepath.ToSlash(outside), "models/../../x.safetensors"} {
+		t.Run(p, func(t *testing.T) {
+			e := ManifestEntry{ModelID: 1, VersionID: 1, Path: p}
+			err := syncEntry(context.Background(), Client{}, nil, root, e, make(map[int64]*models.Model))
+			if !errors.Is(err, ErrPathNotLocal) {
+				t.Errorf("expect %v, got %v", ErrPathNotLocal, err)
+			}
+			if _, err = os.Stat(filepath.Join(filepath.Dir(root), syncDir)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expect no directories are created outside the root, got %v", err)
+			}
+		})
+	}
+}
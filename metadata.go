@@ -0,0 +1,351 @@
+// metadata.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jkawamoto/go-civitai/models"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	// previewExt is the extension of preview images the web UI shows in extra networks cards.
+	previewExt = ".preview.png"
+	// civitaiInfoExt is the extension of files storing the model version information retrieved from Civitai,
+	// which is compatible with Civitai Helper.
+	civitaiInfoExt = ".civitai.info"
+	// userMetadataExt is the extension of files storing metadata of models the user edits in the web UI.
+	userMetadataExt = ".json"
+	// huggingFaceExt is the extension of files storing the Hugging Face repository a model file comes from.
+	huggingFaceExt = ".huggingface.json"
+)
+
+// sidecarExts is a list of extensions of files accompanying model files.
+var sidecarExts = []string{previewExt, civitaiInfoExt, userMetadataExt, huggingFaceExt}
+
+// Keys of the user metadata the web UI uses for LoRAs.
+const (
+	activationTextKey  = "activation text"
+	preferredWeightKey = "preferred weight"
+	sdVersionKey       = "sd version"
+)
+
+// Values of the sd version in the user metadata.
+const (
+	sdVersionSD1     = "SD1"
+	sdVersionSD2     = "SD2"
+	sdVersionSDXL    = "SDXL"
+	sdVersionUnknown = "Unknown"
+)
+
+// sdVersion returns the sd version in the user metadata corresponding to the given base model on Civitai.
+func sdVersion(baseModel string) string {
+	switch b := strings.ToLower(baseModel); {
+	case strings.HasPrefix(b, "sd 1"):
+		return sdVersionSD1
+	case strings.HasPrefix(b, "sd 2"):
+		return sdVersionSD2
+	case strings.HasPrefix(b, "sdxl"), strings.HasPrefix(b, "pony"), strings.HasPrefix(b, "illustrious"),
+		strings.HasPrefix(b, "noobai"):
+		// Pony, Illustrious, and NoobAI are based on SDXL.
+		return sdVersionSDXL
+	default:
+		return sdVersionUnknown
+	}
+}
+
+// isLoRA returns true if the given Civitai model type is one of the LoRA family.
+func isLoRA(modelType string) bool {
+	return strings.EqualFold(modelType, ModelTypeLORA) || strings.EqualFold(modelType, ModelTypeLoCon) ||
+		strings.EqualFold(modelType, ModelTypeDoRA)
+}
+
+// readUserMetadata reads the named user metadata. It returns an empty map if the file doesn't exist.
+func readUserMetadata(name string) (map[string]any, error) {
+	res := make(map[string]any)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", name, err)
+	}
+	return res, nil
+}
+
+// writeUserMetadata writes the activation text, the preferred weight, and the sd version of the given LoRA version
+// into the user metadata of the given model file.
+//
+// Fields the user already edited are kept. If the model file has no user metadata yet,
+// fields other than the activation text and the sd version are copied from the given user metadata of
+// the previous version, if it isn't empty, so that the settings the user made are inherited.
+func writeUserMetadata(name string, ver *models.ModelVersion, prev string) error {
+	name = sidecarPath(name, userMetadataExt)
+	res, err := readUserMetadata(name)
+	if err != nil {
+		// never overwrite metadata the user might have edited.
+		return err
+	}
+	if len(res) == 0 && prev != "" {
+		if res, err = readUserMetadata(prev); err != nil {
+			return err
+		}
+		delete(res, activationTextKey)
+		delete(res, sdVersionKey)
+	}
+
+	if v, ok := res[activationTextKey]; !ok || v == "" {
+		res[activationTextKey] = strings.Join(ver.TrainedWords, ", ")
+	}
+	if v, ok := res[sdVersionKey]; !ok || v == "" || v == sdVersionUnknown {
+		res[sdVersionKey] = sdVersion(ver.BaseModel)
+	}
+	if _, ok := res[preferredWeightKey]; !ok {
+		// zero means the default weight of the web UI.
+		res[preferredWeightKey] = 0
+	}
+
+	data, err := json.MarshalIndent(res, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFile(name, bytes.NewReader(data))
+}
+
+// sidecarPath returns the path to the sidecar file of the given model file with the given extension.
+func sidecarPath(name, ext string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
+
+// sidecars returns existing sidecar files of the given model file.
+func sidecars(name string) []string {
+	var res []string
+	for _, ext := range sidecarExts {
+		if p := sidecarPath(name, ext); p != name {
+			if _, err := os.Stat(p); err == nil {
+				res = append(res, p)
+			}
+		}
+	}
+	return res
+}
+
+// nsfwLevelPG is the NSFW level of images rated PG, which is the only level safe for work.
+// Levels of more explicit ratings are larger.
+const nsfwLevelPG = 1
+
+// previewImage returns the first safe-for-work image of the given version, or nil if there are no such images.
+// Versions may also have videos, which cannot be previews.
+func previewImage(ver *models.ModelVersion) *models.Image {
+	for _, img := range ver.Images {
+		if img != nil && img.Type == "image" && !img.Nsfw && isSafeNsfwLevel(img.NsfwLevel) && img.URL != "" {
+			return img
+		}
+	}
+	return nil
+}
+
+// isSafeNsfwLevel returns true if the given NSFW level of an image is safe for work.
+// The API returns the level as a number, or as a name such as "None" and "Mature" in older responses.
+func isSafeNsfwLevel(level any) bool {
+	switch v := level.(type) {
+	case nil:
+		return true
+	case string:
+		return v == "" || strings.EqualFold(v, "None")
+	case json.Number:
+		n, err := v.Float64()
+		return err == nil && n <= nsfwLevelPG
+	case float64:
+		return v <= nsfwLevelPG
+	case int:
+		return v <= nsfwLevelPG
+	case int64:
+		return v <= nsfwLevelPG
+	default:
+		return false
+	}
+}
+
+// writeFile writes data read from the given reader into the named file.
+// The data is written into a temporary file first, so that a failure never leaves a broken file.
+func writeFile(name string, r io.Reader) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, os.Remove(f.Name()))
+		}
+	}()
+
+	if _, err = io.Copy(f, r); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// civitaiInfo is the information of a version written in .civitai.info. Like responses of the by-hash endpoint,
+// it has the ID and a summary of the model, which Civitai Helper uses to link to the model and check for new versions.
+type civitaiInfo struct {
+	*models.ModelVersion
+	ModelID int64            `json:"modelId"`
+	Model   civitaiInfoModel `json:"model"`
+}
+
+// civitaiInfoModel is the summary of the model in civitaiInfo.
+type civitaiInfoModel struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Nsfw bool   `json:"nsfw"`
+	Poi  bool   `json:"poi"`
+}
+
+// WriteMetadata writes the preview image and the information of the given version of the given model next to the
+// given model file. It keeps existing files so that previews chosen by the user are not overwritten.
+func (cli Client) WriteMetadata(ctx context.Context, m *models.Model, ver *models.ModelVersion, name string) error {
+	var errs []error
+
+	info := sidecarPath(name, civitaiInfoExt)
+	if _, err := os.Stat(info); err != nil {
+		data, err := json.MarshalIndent(civitaiInfo{
+			ModelVersion: ver,
+			ModelID:      m.ID,
+			Model:        civitaiInfoModel{Name: m.Name, Type: m.Type, Nsfw: m.Nsfw, Poi: m.Poi},
+		}, "", "  ")
+		if err == nil {
+			err = writeFile(info, bytes.NewReader(data))
+		}
+		errs = append(errs, err)
+	}
+
+	preview := sidecarPath(name, previewExt)
+	if img := previewImage(ver); img != nil {
+		if _, err := os.Stat(preview); err != nil {
+			errs = append(errs, cli.downloadImage(ctx, img.URL, preview))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// downloadImage downloads the image at the given URL into the named file.
+func (cli Client) downloadImage(ctx context.Context, url, name string) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := ctxhttp.Do(ctx, cli.httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, res.Body.Close())
+	}()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %v", ErrGetFailure, res.Status)
+	}
+
+	return writeFile(name, res.Body)
+}
+
+// fetchMetadata writes the preview images and the information of identified model files in the given targets,
+// and the user metadata of LoRAs.
+func fetchMetadata(ctx context.Context, cli Client, hashes *hashCache, targets []target, jobs int) error {
+	for _, t := range targets {
+		stat, err := os.Stat(t.Path)
+		if err != nil {
+			return err
+		}
+
+		var updates []*Update
+		if !stat.IsDir() {
+			u, err := findUpdate(ctx, cli, hashes, t.Path, scanOptions{})
+			if err != nil {
+				if !isNotFound(err) {
+					return err
+				}
+				fmt.Println(color.YellowString("Model information is not found: %v", filepath.Base(t.Path)))
+				continue
+			}
+			updates = append(updates, u)
+		} else {
+			fmt.Println("Retrieving models in", t.Path)
+			var unknowns []LocalFile
+			updates, unknowns, err = findUpdatesFromDir(ctx, cli, hashes, t.Path, scanOptions{
+				Jobs:    jobs,
+				Exclude: t.Exclude,
+			})
+			if err != nil {
+				return err
+			}
+			for _, f := range unknowns {
+				printUnknown(f)
+			}
+		}
+
+		for _, u := range updates {
+			for _, f := range u.Files {
+				err = cli.WriteMetadata(ctx, u.model(), f.Version, f.Path)
+				if err == nil && isLoRA(u.ModelType) {
+					err = writeUserMetadata(f.Path, f.Version, "")
+				}
+				if err != nil {
+					if ctx.Err() != nil {
+						return err
+					}
+					fmt.Println(color.RedString("Failed to write metadata of %v: %v", filepath.Base(f.Path), err))
+					continue
+				}
+				fmt.Println("Wrote metadata of", filepath.Base(f.Path))
+			}
+		}
+	}
+	return nil
+}
+
+// runFetchMetadata writes the preview images and the information of models in the given files and directories.
+func runFetchMetadata(ctx context.Context, args []string) error {
+	fs := newFlagSet("fetch-metadata", "fetch-metadata [flags] [path ...]")
+	shared := newSharedFlags(fs)
+	shared.addHashFlags(true)
+	_ = fs.Parse(args)
+
+	s, err := shared.settings()
+	if err != nil {
+		return err
+	}
+
+	hashes, closeHashes := s.openHashCache()
+	defer closeHashes()
+
+	cli, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	return fetchMetadata(ctx, cli, hashes, s.targets(fs.Args(), PolicyNone), s.Jobs)
+}
@@ -0,0 +1,295 @@
+// metadata_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+func TestClient_WriteMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nsfw.png", func(res http.ResponseWriter, _ *http.Request) {
+		_, _ = res.Write([]byte("nsfw"))
+	})
+	mux.HandleFunc("/sfw.png", func(res http.ResponseWriter, _ *http.Request) {
+		_, _ = res.Write([]byte("sfw"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	ver := &models.ModelVersion{
+		ID:           1,
+		Name:         "v1",
+		Description:  "description",
+		BaseModel:    "SDXL 1.0",
+		TrainedWords: []string{"trigger"},
+		Images: []*models.Image{
+			{URL: joinURL(t, server.URL, "nsfw.png"), Type: "image", Nsfw: true},
+			{URL: joinURL(t, server.URL, "sfw.png"), Type: "image", NsfwLevel: 1},
+		},
+	}
+	m := &models.Model{ID: 100, Name: "model", Type: ModelTypeLORA}
+	cli := NewClient(SafetensorFormat)
+	cli.httpClient = server.Client()
+
+	t.Run("new files", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "model.safetensors")
+		if err := cli.WriteMetadata(ctx, m, ver, name); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(name), "model"+previewExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "sfw" {
+			t.Errorf("expect the safe-for-work image, got %q", data)
+		}
+
+		data, err = os.ReadFile(filepath.Join(filepath.Dir(name), "model"+civitaiInfoExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var info models.ModelVersion
+		if err = json.Unmarshal(data, &info); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(info.TrainedWords, ver.TrainedWords) || info.BaseModel != ver.BaseModel ||
+			info.Description != ver.Description {
+			t.Errorf("expect %+v, got %+v", ver, info)
+		}
+		// Civitai Helper finds the model by these fields.
+		var model struct {
+			ModelID int64 `json:"modelId"`
+			Model   struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"model"`
+		}
+		if err = json.Unmarshal(data, &model); err != nil {
+			t.Fatal(err)
+		}
+		if model.ModelID != m.ID || model.Model.Name != m.Name || model.Model.Type != m.Type {
+			t.Errorf("expect model %v %v %v, got %+v", m.ID, m.Name, m.Type, model)
+		}
+
+		if res := sidecars(name); len(res) != 2 {
+			t.Errorf("expect 2 sidecars, got %v", res)
+		}
+	})
+
+	t.Run("existing preview", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "model.safetensors")
+		preview := sidecarPath(name, previewExt)
+		if err := os.WriteFile(preview, []byte("custom"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := cli.WriteMetadata(ctx, m, ver, name); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(preview)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "custom" {
+			t.Errorf("expect the preview is kept, got %q", data)
+		}
+	})
+
+	t.Run("no safe-for-work images", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "model.safetensors")
+		ver := *ver
+		ver.Images = ver.Images[:1]
+		if err := cli.WriteMetadata(ctx, m, &ver, name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(sidecarPath(name, previewExt)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expect no previews, got %v", err)
+		}
+	})
+
+	t.Run("missing image", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "model.safetensors")
+		ver := *ver
+		ver.Images = []*models.Image{{URL: joinURL(t, server.URL, "missing.png"), Type: "image"}}
+		if err := cli.WriteMetadata(ctx, m, &ver, name); !errors.Is(err, ErrGetFailure) {
+			t.Errorf("expect %v, got %v", ErrGetFailure, err)
+		}
+		entries, err := os.ReadDir(filepath.Dir(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "model"+civitaiInfoExt {
+			t.Errorf("expect only the info file, got %v", entries)
+		}
+	})
+}
+
+func Test_sdVersion(t *testing.T) {
+	cases := map[string]string{
+		"SD 1.5":     sdVersionSD1,
+		"SD 2.1 768": sdVersionSD2,
+		"SDXL 1.0":   sdVersionSDXL,
+		"Pony":       sdVersionSDXL,
+		"Flux.1 D":   sdVersionUnknown,
+		"":           sdVersionUnknown,
+	}
+	for baseModel, expect := range cases {
+		if res := sdVersion(baseModel); res != expect {
+			t.Errorf("%q: expect %v, got %v", baseModel, expect, res)
+		}
+	}
+}
+
+func Test_writeUserMetadata(t *testing.T) {
+	ver := &models.ModelVersion{
+		Name:         "v2",
+		BaseModel:    "SDXL 1.0",
+		TrainedWords: []string{"new trigger", "style"},
+	}
+
+	cases := []struct {
+		name     string
+		existing map[string]any
+		prev     map[string]any
+		expect   map[string]any
+	}{
+		{
+			name: "new file",
+			expect: map[string]any{
+				activationTextKey:  "new trigger, style",
+				sdVersionKey:       sdVersionSDXL,
+				preferredWeightKey: 0.,
+			},
+		},
+		{
+			name: "edited by the user",
+			existing: map[string]any{
+				activationTextKey: "my trigger",
+				sdVersionKey:      sdVersionUnknown,
+				"notes":           "my notes",
+			},
+			expect: map[string]any{
+				activationTextKey:  "my trigger",
+				sdVersionKey:       sdVersionSDXL,
+				preferredWeightKey: 0.,
+				"notes":            "my notes",
+			},
+		},
+		{
+			name: "inherit the previous version",
+			prev: map[string]any{
+				activationTextKey:  "old trigger",
+				sdVersionKey:       sdVersionSD1,
+				preferredWeightKey: 0.8,
+				"notes":            "my notes",
+			},
+			expect: map[string]any{
+				activationTextKey:  "new trigger, style",
+				sdVersionKey:       sdVersionSDXL,
+				preferredWeightKey: 0.8,
+				"notes":            "my notes",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			write := func(name string, v map[string]any) {
+				t.Helper()
+				data, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(name, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			name := filepath.Join(dir, "v2.safetensors")
+			if c.existing != nil {
+				write(sidecarPath(name, userMetadataExt), c.existing)
+			}
+			var prev string
+			if c.prev != nil {
+				prev = filepath.Join(dir, "v1"+userMetadataExt)
+				write(prev, c.prev)
+			}
+
+			if err := writeUserMetadata(name, ver, prev); err != nil {
+				t.Fatal(err)
+			}
+			res, err := readUserMetadata(sidecarPath(name, userMetadataExt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res, c.expect) {
+				t.Errorf("expect %v, got %v", c.expect, res)
+			}
+		})
+	}
+
+	t.Run("broken file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "v2.safetensors")
+		if err := os.WriteFile(sidecarPath(name, userMetadataExt), []byte("{"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeUserMetadata(name, ver, ""); err == nil {
+			t.Error("expect an error")
+		}
+		data, err := os.ReadFile(sidecarPath(name, userMetadataExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "{" {
+			t.Errorf("expect the file is kept, got %q", data)
+		}
+	})
+}
+
+func Test_previewImage(t *testing.T) {
+	const u = "https://image.civitai.com/preview.png"
+	cases := []struct {
+		name   string
+		image  *models.Image
+		expect bool
+	}{
+		{name: "image", image: &models.Image{URL: u, Type: "image"}, expect: true},
+		{name: "PG", image: &models.Image{URL: u, Type: "image", NsfwLevel: 1}, expect: true},
+		{name: "PG in JSON", image: &models.Image{URL: u, Type: "image", NsfwLevel: json.Number("1")}, expect: true},
+		{name: "None", image: &models.Image{URL: u, Type: "image", NsfwLevel: "None"}, expect: true},
+		{name: "video", image: &models.Image{URL: u, Type: "video"}},
+		{name: "no type", image: &models.Image{URL: u}},
+		{name: "nsfw", image: &models.Image{URL: u, Type: "image", Nsfw: true}},
+		{name: "R", image: &models.Image{URL: u, Type: "image", NsfwLevel: float64(4)}},
+		{name: "Mature", image: &models.Image{URL: u, Type: "image", NsfwLevel: "Mature"}},
+		{name: "no URL", image: &models.Image{Type: "image"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := previewImage(&models.ModelVersion{Images: []*models.Image{c.image}})
+			if (res != nil) != c.expect {
+				t.Errorf("expect the image is a preview: %v, got %v", c.expect, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// prompt.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jkawamoto/go-civitai/models"
+	"golang.org/x/net/html"
+)
+
+// formatSize returns a human-readable representation of the given file size in kilobytes.
+func formatSize(kb float64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	i := 0
+	for ; kb >= 1024 && i < len(units)-1; i++ {
+		kb /= 1024
+	}
+	return fmt.Sprintf("%.1f %v", kb, units[i])
+}
+
+// versionDetails returns the base model, the publish date, and the size and format of the given file of the given
+// version to show in prompts. The file can be nil.
+func versionDetails(v *models.ModelVersion, file *models.File) string {
+	var res []string
+	if v.BaseModel != "" {
+		res = append(res, v.BaseModel)
+	}
+	if t := time.Time(v.PublishedAt); !t.IsZero() {
+		res = append(res, t.Format(time.DateOnly))
+	}
+	if file != nil {
+		res = append(res, strings.TrimSpace(formatSize(file.SizeKB)+" "+file.Format))
+	}
+	return strings.Join(res, ", ")
+}
+
+// versionLabel returns the name of the given version with its details to show in prompts.
+func versionLabel(v *models.ModelVersion, file *models.File) string {
+	if d := versionDetails(v, file); d != "" {
+		return fmt.Sprintf("%v (%v)", v.Name, d)
+	}
+	return v.Name
+}
+
+// versionHelp returns descriptions of the given versions to show as the help of prompts.
+func versionHelp(versions []*models.ModelVersion) string {
+	var res []string
+	for _, v := range versions {
+		desc := stripHTML(v.Description)
+		if desc == "" {
+			desc = "(no description)"
+		}
+		res = append(res, fmt.Sprintf("%v:\n%v", v.Name, desc))
+	}
+	return strings.Join(res, "\n\n")
+}
+
+// blankLines matches runs of blank lines.
+var blankLines = regexp.MustCompile(`\n\s*\n`)
+
+// stripHTML returns the text in the given HTML. Block elements and line breaks become new lines.
+func stripHTML(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				// keep the rest as it is.
+				b.Write(z.Raw())
+			}
+			return strings.TrimSpace(blankLines.ReplaceAllString(b.String(), "\n\n"))
+
+		case html.TextToken:
+			b.Write(z.Text())
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "br", "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "pre", "blockquote":
+				b.WriteString("\n")
+			case "li":
+				if tt == html.StartTagToken {
+					b.WriteString("\n- ")
+				}
+			}
+		}
+	}
+}
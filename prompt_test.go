@@ -0,0 +1,101 @@
+// prompt_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+func Test_formatSize(t *testing.T) {
+	cases := map[float64]string{
+		0:                 "0.0 KB",
+		512:               "512.0 KB",
+		1536:              "1.5 MB",
+		6.5 * 1024 * 1024: "6.5 GB",
+	}
+	for kb, expect := range cases {
+		if res := formatSize(kb); res != expect {
+			t.Errorf("%v: expect %v, got %v", kb, expect, res)
+		}
+	}
+}
+
+func Test_versionLabel(t *testing.T) {
+	published := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name   string
+		ver    *models.ModelVersion
+		file   *models.File
+		expect string
+	}{
+		{
+			name: "all details",
+			ver: &models.ModelVersion{
+				Name:        "v2",
+				BaseModel:   "SDXL 1.0",
+				PublishedAt: strfmt.DateTime(published),
+			},
+			file:   &models.File{SizeKB: 2048, Format: "SafeTensor"},
+			expect: "v2 (SDXL 1.0, 2025-01-02, 2.0 MB SafeTensor)",
+		},
+		{
+			name:   "no files",
+			ver:    &models.ModelVersion{Name: "v2", PublishedAt: strfmt.DateTime(published)},
+			expect: "v2 (2025-01-02)",
+		},
+		{
+			name:   "no details",
+			ver:    &models.ModelVersion{Name: "v2"},
+			expect: "v2",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := versionLabel(c.ver, c.file); res != c.expect {
+				t.Errorf("expect %q, got %q", c.expect, res)
+			}
+		})
+	}
+}
+
+func Test_stripHTML(t *testing.T) {
+	cases := []struct {
+		name   string
+		html   string
+		expect string
+	}{
+		{name: "plain text", html: "new version", expect: "new version"},
+		{name: "paragraphs", html: "<p>first</p><p>second <b>bold</b></p>", expect: "first\n\nsecond bold"},
+		{name: "line breaks", html: "first<br>second<br/>third", expect: "first\nsecond\nthird"},
+		{name: "list", html: "<p>changes:</p><ul><li>a</li><li>b</li></ul>", expect: "changes:\n\n- a\n- b"},
+		{name: "entities", html: "<p>a &amp; b</p>", expect: "a & b"},
+		{name: "empty", html: "", expect: ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := stripHTML(c.html); res != c.expect {
+				t.Errorf("expect %q, got %q", c.expect, res)
+			}
+		})
+	}
+}
+
+func Test_versionHelp(t *testing.T) {
+	res := versionHelp([]*models.ModelVersion{
+		{Name: "v2", Description: "<p>fixed colors</p>"},
+		{Name: "v3"},
+	})
+	if expect := "v2:\nfixed colors\n\nv3:\n(no description)"; res != expect {
+		t.Errorf("expect %q, got %q", expect, res)
+	}
+}
@@ -0,0 +1,180 @@
+// report.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+const (
+	TextOutput = "text"
+	JSONOutput = "json"
+)
+
+// Report is a machine-readable result of checking for updates.
+type Report struct {
+	Files []FileReport `json:"files"`
+}
+
+// FileReport describes a scanned model file and newer versions of its model.
+type FileReport struct {
+	Path       string          `json:"path"`
+	Hash       string          `json:"hash,omitempty"`
+	Model      *ModelReport    `json:"model,omitempty"`
+	Version    *VersionReport  `json:"version,omitempty"`
+	Candidates []VersionReport `json:"candidates,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// ModelReport identifies a model on Civitai.
+type ModelReport struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+// VersionReport describes a model version on Civitai.
+type VersionReport struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	PublishedAt time.Time    `json:"publishedAt"`
+	Files       []FileDetail `json:"files,omitempty"`
+}
+
+// FileDetail describes a downloadable file of a model version.
+type FileDetail struct {
+	Name        string  `json:"name"`
+	Format      string  `json:"format"`
+	SizeKB      float64 `json:"sizeKB"`
+	Primary     bool    `json:"primary"`
+	DownloadURL string  `json:"downloadUrl"`
+}
+
+func newVersionReport(v *models.ModelVersion) VersionReport {
+	res := VersionReport{
+		ID:          v.ID,
+		Name:        v.Name,
+		PublishedAt: time.Time(v.PublishedAt),
+	}
+	for _, f := range v.Files {
+		res.Files = append(res.Files, FileDetail{
+			Name:        f.Name,
+			Format:      f.Format,
+			SizeKB:      f.SizeKB,
+			Primary:     f.Primary,
+			DownloadURL: f.DownloadURL,
+		})
+	}
+	return res
+}
+
+// addUpdate adds reports of the local files of the given update.
+func (r *Report) addUpdate(u *Update) {
+	for _, f := range u.Files {
+		res := FileReport{
+			Path: f.Path,
+			Hash: f.Hash,
+			Model: &ModelReport{
+				ID:   u.ModelID,
+				Name: u.ModelName,
+				Type: u.ModelType,
+			},
+		}
+		if f.Version != nil {
+			v := newVersionReport(f.Version)
+			res.Version = &v
+		}
+		for _, v := range u.Candidates {
+			res.Candidates = append(res.Candidates, newVersionReport(v))
+		}
+		r.Files = append(r.Files, res)
+	}
+}
+
+// addError adds a report of a file that couldn't be checked.
+func (r *Report) addError(f LocalFile, err error) {
+	r.Files = append(r.Files, FileReport{
+		Path:  f.Path,
+		Hash:  f.Hash,
+		Error: err.Error(),
+	})
+}
+
+// write writes the report in JSON sorted by file paths.
+func (r *Report) write(w io.Writer) error {
+	if r.Files == nil {
+		r.Files = []FileReport{}
+	}
+	sort.Slice(r.Files, func(i, j int) bool {
+		return r.Files[i].Path < r.Files[j].Path
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// checkTargets checks for updates to the given files and files in the given directories without downloading
+// any models, and writes the report to the given writer. Exclude in the options is overridden by each target.
+func checkTargets(
+	ctx context.Context, cli Client, hashes *hashCache, targets []target, opts scanOptions, w io.Writer,
+) error {
+	var report Report
+	for _, t := range targets {
+		name := t.Path
+		stat, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+
+		cli.PreferredFormat = t.Format
+		if !stat.IsDir() {
+			update, err := findUpdate(ctx, cli, hashes, name, opts)
+			if err != nil {
+				if isNotFound(err) {
+					err = ErrModelNotFound
+				}
+				// the hash has been cached while finding the update.
+				hash, _ := hashes.lookupHash(name, nil)
+				report.addError(LocalFile{Path: name, Hash: hash}, err)
+				continue
+			}
+			report.addUpdate(update)
+		} else {
+			opts.Exclude = t.Exclude
+			updates, unknowns, err := findUpdatesFromDir(ctx, cli, hashes, name, opts)
+			if err != nil {
+				report.addError(LocalFile{Path: name}, err)
+				continue
+			}
+			for _, u := range updates {
+				report.addUpdate(u)
+			}
+			for _, f := range unknowns {
+				if f.Err != nil {
+					report.addError(f, f.Err)
+				} else {
+					report.addError(f, ErrModelNotFound)
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return report.write(w)
+}
@@ -0,0 +1,90 @@
+// report_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+func TestReport(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	version := func(id int64, name string, published time.Time) *models.ModelVersion {
+		return &models.ModelVersion{
+			ID:          id,
+			Name:        name,
+			PublishedAt: strfmt.DateTime(published),
+			Files: []*models.File{
+				{
+					Name:        name + ".safetensors",
+					Format:      "SafeTensor",
+					SizeKB:      1024,
+					Primary:     true,
+					DownloadURL: "https://example.com/" + name,
+				},
+			},
+		}
+	}
+	v1 := version(1, "v1", now)
+	v2 := version(2, "v2", now.Add(time.Hour))
+	v3 := version(3, "v3", now.Add(2*time.Hour))
+
+	var r Report
+	r.addUpdate(&Update{
+		ModelID:        10,
+		ModelName:      "model",
+		CurrentVersion: v1.Name,
+		Candidates:     modelVersionList{v3, v2},
+		Files:          []LocalFile{{Path: "b.safetensors", Hash: "hash-b", Version: v1}},
+	})
+	r.addError(LocalFile{Path: "a.safetensors", Hash: "hash-a"}, ErrModelNotFound)
+
+	var buf bytes.Buffer
+	if err := r.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var res Report
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 2 {
+		t.Fatalf("expect 2 files, got %v", len(res.Files))
+	}
+
+	unknown := res.Files[0]
+	if unknown.Path != "a.safetensors" || unknown.Hash != "hash-a" || unknown.Error != ErrModelNotFound.Error() {
+		t.Errorf("unexpected report: %+v", unknown)
+	}
+	if unknown.Model != nil || unknown.Version != nil || len(unknown.Candidates) != 0 {
+		t.Errorf("expect no model information, got %+v", unknown)
+	}
+
+	known := res.Files[1]
+	if known.Path != "b.safetensors" || known.Hash != "hash-b" || known.Error != "" {
+		t.Errorf("unexpected report: %+v", known)
+	}
+	if known.Model == nil || known.Model.ID != 10 || known.Model.Name != "model" {
+		t.Errorf("unexpected model: %+v", known.Model)
+	}
+	if known.Version == nil || known.Version.ID != v1.ID || !known.Version.PublishedAt.Equal(now) {
+		t.Errorf("unexpected version: %+v", known.Version)
+	}
+	if len(known.Candidates) != 2 || known.Candidates[0].ID != v3.ID || known.Candidates[1].ID != v2.ID {
+		t.Fatalf("expect candidates sorted newest first, got %+v", known.Candidates)
+	}
+	if f := known.Candidates[0].Files; len(f) != 1 || f[0].DownloadURL != "https://example.com/v3" || f[0].SizeKB != 1024 {
+		t.Errorf("unexpected files: %+v", f)
+	}
+}
@@ -0,0 +1,167 @@
+// responsecache.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCacheTTL is the default time responses of the Civitai API are used without asking the server.
+const defaultCacheTTL = time.Hour
+
+// defaultResponseCacheDir returns the directory to store cached responses of the Civitai API in.
+func defaultResponseCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sd-model-updater", "api"), nil
+}
+
+// cachedResponse is a successful or not found response stored in a file.
+type cachedResponse struct {
+	URL string `json:"url"`
+	// StatusCode is the status code of the response. Zero means 200.
+	StatusCode int         `json:"status,omitempty"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Time is when the response was received or revalidated.
+	Time time.Time `json:"time"`
+}
+
+// response returns the cached response as a response to the given request.
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	code := c.StatusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %v", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// etag returns the ETag of the cached response, or an empty string if there is no cached response.
+func (c *cachedResponse) etag() string {
+	if c == nil {
+		return ""
+	}
+	return c.Header.Get("ETag")
+}
+
+// responseCache is an http.RoundTripper that stores successful and not found responses to GET requests in a directory,
+// so that files unknown to Civitai aren't looked up again either.
+// A cached response is returned without sending the request until its TTL expires; after that, the request is sent
+// with the ETag of the response in If-None-Match, and the response is used again if the server tells it is not
+// modified. Failures to read or write the cache are ignored, so that the cache never breaks requests.
+type responseCache struct {
+	dir  string
+	ttl  time.Duration
+	base http.RoundTripper
+}
+
+// path returns the path to the file storing the response to the given request.
+// Requests with different credentials are stored separately since they may get different responses.
+func (c responseCache) path(req *http.Request) string {
+	key := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Authorization")))
+	return filepath.Join(c.dir, hex.EncodeToString(key[:])+".json")
+}
+
+// load reads the named cached response. It returns nil if the response is not cached or broken.
+func (c responseCache) load(name string) *cachedResponse {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	var res cachedResponse
+	if json.Unmarshal(data, &res) != nil {
+		return nil
+	}
+	return &res
+}
+
+// store writes the given response into the named file.
+func (c responseCache) store(name string, res *cachedResponse) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	return writeFile(name, bytes.NewReader(data))
+}
+
+func (c responseCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.base.RoundTrip(req)
+	}
+
+	name := c.path(req)
+	cached := c.load(name)
+	if cached != nil && time.Since(cached.Time) < c.ttl {
+		return cached.response(req), nil
+	}
+
+	r := req
+	if etag := cached.etag(); etag != "" {
+		r = req.Clone(req.Context())
+		r.Header.Set("If-None-Match", etag)
+	}
+	res, err := c.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		_, err = io.Copy(io.Discard, res.Body)
+		if err = errors.Join(err, res.Body.Close()); err != nil {
+			return nil, err
+		}
+		cached.Time = time.Now()
+		_ = c.store(name, cached)
+		return cached.response(req), nil
+
+	case res.StatusCode == http.StatusOK || res.StatusCode == http.StatusNotFound:
+		body, err := io.ReadAll(res.Body)
+		if err = errors.Join(err, res.Body.Close()); err != nil {
+			return nil, err
+		}
+		_ = c.store(name, &cachedResponse{
+			URL:        req.URL.String(),
+			StatusCode: res.StatusCode,
+			Header:     res.Header,
+			Body:       body,
+			Time:       time.Now(),
+		})
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		return res, nil
+
+	default:
+		return res, nil
+	}
+}
@@ -0,0 +1,128 @@
+// responsecache_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_responseCache(t *testing.T) {
+	const etag = `"v1"`
+	cases := []struct {
+		name string
+		ttl  time.Duration
+		// requests are requests sent with the given Authorization header in order.
+		requests []string
+		method   string
+		// status is the status code of the responses, which is 200 if zero.
+		status int
+		// expect is the number of requests the server receives, and notModified is the number of 304 responses.
+		expect, notModified int
+	}{
+		{name: "fresh", ttl: time.Hour, requests: []string{"", ""}, method: http.MethodGet, expect: 1},
+		{name: "revalidated", ttl: time.Nanosecond, requests: []string{"", "", ""}, method: http.MethodGet,
+			expect: 3, notModified: 2},
+		{name: "different credentials", ttl: time.Hour, requests: []string{"", "Bearer a", "Bearer b", "Bearer a"},
+			method: http.MethodGet, expect: 3},
+		{name: "not GET", ttl: time.Hour, requests: []string{"", ""}, method: http.MethodPost, expect: 2},
+		{name: "not found", ttl: time.Hour, requests: []string{"", ""}, method: http.MethodGet,
+			status: http.StatusNotFound, expect: 1},
+		{name: "server error", ttl: time.Hour, requests: []string{"", ""}, method: http.MethodGet,
+			status: http.StatusInternalServerError, expect: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status := c.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			var requests, notModified int
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				requests++
+				if req.Header.Get("If-None-Match") == etag {
+					notModified++
+					res.WriteHeader(http.StatusNotModified)
+					return
+				}
+				res.Header().Set("ETag", etag)
+				res.WriteHeader(status)
+				if _, err := res.Write([]byte(`{"id": 1}`)); err != nil {
+					t.Error(err)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			dir := filepath.Join(t.TempDir(), "api")
+			cli := &http.Client{Transport: responseCache{dir: dir, ttl: c.ttl, base: http.DefaultTransport}}
+			for _, auth := range c.requests {
+				req, err := http.NewRequest(c.method, server.URL+"/models/1", strings.NewReader(""))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if auth != "" {
+					req.Header.Set("Authorization", auth)
+				}
+				res, err := cli.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(res.Body)
+				if err = errors.Join(err, res.Body.Close()); err != nil {
+					t.Fatal(err)
+				}
+				if res.StatusCode != status || string(body) != `{"id": 1}` {
+					t.Errorf("unexpected response: %v %q", res.Status, body)
+				}
+			}
+			if requests != c.expect || notModified != c.notModified {
+				t.Errorf("expect %v requests and %v not modified, got %v and %v",
+					c.expect, c.notModified, requests, notModified)
+			}
+		})
+	}
+}
+
+func Test_responseCache_errors(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	// the cache directory cannot be created since a file has the name.
+	dir := filepath.Join(t.TempDir(), "api")
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cli := &http.Client{Transport: responseCache{dir: dir, ttl: time.Hour, base: http.DefaultTransport}}
+	for range 2 {
+		res, err := cli.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = res.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expect %v, got %v", http.StatusServiceUnavailable, res.Status)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expect failures are not cached, got %v requests", requests)
+	}
+}
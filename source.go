@@ -0,0 +1,97 @@
+// source.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+// Source is a service hosting models, such as Civitai.
+// Models and versions of every source are described with the Civitai data types.
+type Source interface {
+	// LookupHash returns the model and the version of the local file at the given path with the given hash.
+	// The returned model only needs to identify the model; FetchModel retrieves its details.
+	// It returns an error wrapping ErrModelNotFound or a 404 error if the source doesn't know the file.
+	LookupHash(ctx context.Context, name, hash string) (*models.Model, *models.ModelVersion, error)
+	// FetchModel returns the details of the given model returned by LookupHash.
+	FetchModel(ctx context.Context, m *models.Model) (*models.Model, error)
+	// ListVersions returns the versions of the given model returned by FetchModel.
+	ListVersions(ctx context.Context, m *models.Model) ([]*models.ModelVersion, error)
+	// Download gets the file of the given version, stores it into the given directory,
+	// and returns the path to the stored file.
+	Download(ctx context.Context, ver *models.ModelVersion, dir string) (string, error)
+}
+
+// LookupHash returns the model version that has a file with the given hash and the model it belongs to.
+// The name of the file is not used since Civitai identifies files by their hashes.
+func (cli Client) LookupHash(ctx context.Context, _, hash string) (*models.Model, *models.ModelVersion, error) {
+	ver, id, err := cli.GetModelVersion(ctx, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.Model{ID: id}, ver, nil
+}
+
+// FetchModel returns the model with the ID of the given model.
+func (cli Client) FetchModel(ctx context.Context, m *models.Model) (*models.Model, error) {
+	return cli.GetModel(ctx, m.ID)
+}
+
+// ListVersions returns the versions of the given model, which Civitai includes in the model.
+func (cli Client) ListVersions(_ context.Context, m *models.Model) ([]*models.ModelVersion, error) {
+	return m.ModelVersions, nil
+}
+
+// lookupHash asks the given sources in order about the local file at the given path with the given hash,
+// and returns the index of the first source that knows the file with the model and the version of the file.
+// If no sources know the file, it returns the error of the first source.
+func lookupHash(
+	ctx context.Context, sources []Source, name, hash string,
+) (int, *models.Model, *models.ModelVersion, error) {
+	var notFound error
+	for i, src := range sources {
+		m, ver, err := src.LookupHash(ctx, name, hash)
+		if err == nil {
+			return i, m, ver, nil
+		}
+		if !isNotFound(err) {
+			return 0, nil, nil, err
+		}
+		if notFound == nil {
+			notFound = err
+		}
+	}
+	return 0, nil, nil, notFound
+}
+
+// fetchUpdate retrieves the details and the versions of the given model from the given source,
+// and creates an update of the model from the given local files.
+func fetchUpdate(
+	ctx context.Context, src Source, m *models.Model, files []LocalFile, anyBaseModel bool,
+) (*Update, error) {
+	m, err := src.FetchModel(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := src.ListVersions(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range files {
+		if v, _ := findVersionFile(versions, f.Hash); v != nil {
+			files[i].Version = v
+		}
+	}
+	res := newUpdate(m, versions, files, anyBaseModel)
+	res.Source = src
+	return res, nil
+}
@@ -0,0 +1,183 @@
+// source_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/jkawamoto/go-civitai/models"
+)
+
+// fakeSource is a Source that knows local files by their names.
+type fakeSource struct {
+	// files maps names of local files to their versions.
+	files map[string]*models.ModelVersion
+	// versions is a list of versions of the model, which is named "fake".
+	versions []*models.ModelVersion
+	// failures maps names of local files to errors returned when looking them up.
+	failures map[string]error
+	// noBar counts lookups whose contexts don't carry progress bars.
+	noBar int
+}
+
+func (s *fakeSource) LookupHash(ctx context.Context, name, _ string) (*models.Model, *models.ModelVersion, error) {
+	if hashBar(ctx) == nil {
+		s.noBar++
+	}
+	if err := s.failures[filepath.Base(name)]; err != nil {
+		return nil, nil, err
+	}
+	v, ok := s.files[filepath.Base(name)]
+	if !ok {
+		return nil, nil, ErrModelNotFound
+	}
+	return &models.Model{Name: "fake"}, v, nil
+}
+
+func (s *fakeSource) FetchModel(_ context.Context, m *models.Model) (*models.Model, error) {
+	return m, nil
+}
+
+func (s *fakeSource) ListVersions(context.Context, *models.Model) ([]*models.ModelVersion, error) {
+	return s.versions, nil
+}
+
+func (s *fakeSource) Download(_ context.Context, ver *models.ModelVersion, dir string) (string, error) {
+	name := filepath.Join(dir, ver.Name+".safetensors")
+	return name, os.WriteFile(name, []byte(ver.Name), 0644)
+}
+
+func Test_lookupHash(t *testing.T) {
+	ctx := context.Background()
+	v1 := &models.ModelVersion{Name: "v1"}
+	known := &fakeSource{files: map[string]*models.ModelVersion{"v1.safetensors": v1}}
+	unknown := &fakeSource{}
+	failure := &fakeSource{failures: map[string]error{"v1.safetensors": ErrGetFailure}}
+
+	cases := []struct {
+		name    string
+		sources []Source
+		index   int
+		err     error
+	}{
+		{name: "first source", sources: []Source{known, unknown}, index: 0},
+		{name: "second source", sources: []Source{unknown, known}, index: 1},
+		{name: "not found", sources: []Source{unknown, unknown}, err: ErrModelNotFound},
+		{name: "failure", sources: []Source{failure, known}, err: ErrGetFailure},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			i, _, v, err := lookupHash(ctx, c.sources, "v1.safetensors", "")
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("expect %v, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != c.index || v != v1 {
+				t.Errorf("expect source %v and %v, got %v and %v", c.index, v1, i, v)
+			}
+		})
+	}
+}
+
+func Test_findUpdatesFromDir_sources(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	v1 := &models.ModelVersion{Name: "fake-v1", PublishedAt: strfmt.DateTime(now)}
+	v2 := &models.ModelVersion{Name: "fake-v2", PublishedAt: strfmt.DateTime(now.Add(time.Hour))}
+	src := &fakeSource{
+		files:    map[string]*models.ModelVersion{"fake-v1.safetensors": v1},
+		versions: []*models.ModelVersion{v1, v2},
+		failures: map[string]error{"broken.safetensors": ErrGetFailure},
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"fake-v1", "unknown", "broken"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".safetensors"), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cli := (&fakeService{}).newClient(t)
+	updates, unknowns, err := findUpdatesFromDir(ctx, cli, nil, dir, scanOptions{Sources: []Source{src}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a failure of a file is reported with the file and doesn't abort the scan.
+	if len(unknowns) != 2 ||
+		filepath.Base(unknowns[0].Path) != "broken.safetensors" || !errors.Is(unknowns[0].Err, ErrGetFailure) ||
+		filepath.Base(unknowns[1].Path) != "unknown.safetensors" || unknowns[1].Err != nil {
+		t.Errorf("unexpected unknown files: %+v", unknowns)
+	}
+	// sources hashing files show the progress in the bars of the workers.
+	if src.noBar != 0 {
+		t.Errorf("expect all lookups have progress bars, got %v without them", src.noBar)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("expect 1 update, got %v", len(updates))
+	}
+	u := updates[0]
+	if u.ModelName != "fake" || u.CurrentVersion != "fake-v1" || len(u.Candidates) != 1 || u.Candidates[0] != v2 {
+		t.Errorf("unexpected update: %+v", u)
+	}
+
+	if err = u.run(ctx, cli, dir, updateOptions{Policy: PolicyLatest, Old: OldVersionKeep}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "fake-v2.safetensors")); err != nil {
+		t.Errorf("expect the new version is downloaded from the source: %v", err)
+	}
+}
+
+func TestClient_LookupHash(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.URL.Path != "/model-versions/by-hash/abc" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := res.Write([]byte(`{"id": 5678, "modelId": 1234, "name": "v1"}`)); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cli, err := NewClient(SafetensorFormat).WithHTTPClient(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ver, err := cli.LookupHash(context.Background(), "model.safetensors", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 1234 || ver.ID != 5678 || ver.Name != "v1" {
+		t.Errorf("expect model 1234 and version 5678, got %v and %+v", m.ID, ver)
+	}
+	// the model ID comes with the version, so no other requests are needed.
+	if requests != 1 {
+		t.Errorf("expect 1 request, got %v", requests)
+	}
+
+	if _, _, err = cli.LookupHash(context.Background(), "model.safetensors", "unknown"); !isNotFound(err) {
+		t.Errorf("expect a not found error, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+// transport.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultUserAgent is the User-Agent header sent with requests unless another one is configured.
+const defaultUserAgent = "sd-model-updater"
+
+// defaultRateLimit is the default maximum number of requests per second to the Civitai API.
+const defaultRateLimit = 5
+
+const (
+	// defaultAttempts is the default number of attempts of each request.
+	defaultAttempts = 4
+	// retryWait is the wait before the first retry. It doubles at every retry up to maxRetryWait.
+	retryWait = time.Second
+	// maxRetryWait is the maximum wait before a retry unless the server asks to wait longer.
+	maxRetryWait = time.Minute
+)
+
+var (
+	ErrInvalidProxy    = errors.New("invalid proxy URL")
+	ErrInvalidCABundle = errors.New("no certificates are found in the CA bundle")
+)
+
+// HTTPOptions configures the HTTP client accessing Civitai and the other sources.
+type HTTPOptions struct {
+	// Proxy is the URL of an HTTP(S) proxy. If it is empty, proxies given by the environment variables are used.
+	Proxy string
+	// Timeout limits the time to connect to a server and to wait for its response headers.
+	// It doesn't limit reading response bodies, such as model files. Zero means no limit.
+	Timeout time.Duration
+	// CABundle is the path to a PEM file of CA certificates trusted in addition to the system ones.
+	CABundle string
+	// UserAgent is the User-Agent header sent with requests.
+	UserAgent string
+	// Attempts is the maximum number of attempts of each request failed with 429, 5xx, or a network error.
+	// One or less means no retries.
+	Attempts int
+}
+
+// newHTTPClient creates an HTTP client configured with the given options.
+func newHTTPClient(opts HTTPOptions) (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != "" {
+		u, err := url.Parse(opts.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, opts.Proxy)
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+	if opts.Timeout > 0 {
+		dialer := &net.Dialer{Timeout: opts.Timeout, KeepAlive: 30 * time.Second}
+		tr.DialContext = dialer.DialContext
+		tr.TLSHandshakeTimeout = opts.Timeout
+		tr.ResponseHeaderTimeout = opts.Timeout
+	}
+	if opts.CABundle != "" {
+		data, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCABundle, opts.CABundle)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{
+		Transport: userAgentTransport{
+			userAgent: firstNonEmpty(opts.UserAgent, defaultUserAgent),
+			base: retryTransport{
+				attempts: opts.Attempts,
+				wait:     retryWait,
+				maxWait:  maxRetryWait,
+				base:     tr,
+			},
+		},
+	}, nil
+}
+
+// userAgentTransport is an http.RoundTripper that sets the User-Agent header of requests.
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the given request.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// retryTransport is an http.RoundTripper that retries requests failed with 429, 5xx, or a network error.
+// It waits for the time the Retry-After header tells, or with exponential backoff and jitter.
+type retryTransport struct {
+	// attempts is the maximum number of attempts of each request.
+	attempts int
+	// wait is the wait before the first retry, and maxWait is the maximum wait the backoff reaches.
+	wait, maxWait time.Duration
+	base          http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body cannot be sent again.
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		res, err := t.base.RoundTrip(r)
+		if attempt >= t.attempts || !shouldRetry(res, err) || req.Context().Err() != nil {
+			return res, err
+		}
+
+		wait := t.backoff(attempt, res)
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// shouldRetry returns true if the given result of a request is a temporary failure.
+func shouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the wait before retrying the given attempt that got the given response.
+func (t retryTransport) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if v := res.Header.Get("Retry-After"); v != "" {
+			if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+				return time.Duration(sec) * time.Second
+			}
+			if date, err := http.ParseTime(v); err == nil {
+				return max(time.Until(date), 0)
+			}
+		}
+	}
+
+	wait := t.maxWait
+	if attempt < 32 {
+		wait = min(t.wait<<(attempt-1), t.maxWait)
+	}
+	// a random half of the wait spreads retries of concurrent requests.
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// rateLimitTransport is an http.RoundTripper that waits for a token of the given limiter before sending a request.
+type rateLimitTransport struct {
+	limiter *rate.Limiter
+	base    http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,227 @@
+// transport_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func Test_newHTTPClient(t *testing.T) {
+	t.Run("user agent", func(t *testing.T) {
+		var userAgent string
+		server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			userAgent = req.UserAgent()
+		}))
+		t.Cleanup(server.Close)
+
+		for _, expect := range []string{"", "test"} {
+			cli, err := newHTTPClient(HTTPOptions{UserAgent: expect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = cli.Get(server.URL); err != nil {
+				t.Fatal(err)
+			}
+			if expect = firstNonEmpty(expect, defaultUserAgent); userAgent != expect {
+				t.Errorf("expect %v, got %v", expect, userAgent)
+			}
+		}
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		var requested string
+		proxy := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			requested = req.URL.String()
+		}))
+		t.Cleanup(proxy.Close)
+
+		cli, err := newHTTPClient(HTTPOptions{Proxy: proxy.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.Get("http://civitai.invalid/api/v1/models/1"); err != nil {
+			t.Fatal(err)
+		}
+		if expect := "http://civitai.invalid/api/v1/models/1"; requested != expect {
+			t.Errorf("expect the proxy receives %v, got %v", expect, requested)
+		}
+
+		if _, err = newHTTPClient(HTTPOptions{Proxy: "proxy:3128"}); !errors.Is(err, ErrInvalidProxy) {
+			t.Errorf("expect %v, got %v", ErrInvalidProxy, err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		t.Cleanup(server.Close)
+
+		cli, err := newHTTPClient(HTTPOptions{Timeout: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.Get(server.URL); err == nil {
+			t.Error("expect a timeout error")
+		}
+	})
+
+	t.Run("CA bundle", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		t.Cleanup(server.Close)
+
+		bundle := filepath.Join(t.TempDir(), "ca.pem")
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := os.WriteFile(bundle, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cli, err := newHTTPClient(HTTPOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.Get(server.URL); err == nil {
+			t.Error("expect the server certificate is not trusted")
+		}
+
+		cli, err = newHTTPClient(HTTPOptions{CABundle: bundle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.Get(server.URL); err != nil {
+			t.Error(err)
+		}
+
+		if _, err = newHTTPClient(HTTPOptions{CABundle: "transport.go"}); !errors.Is(err, ErrInvalidCABundle) {
+			t.Errorf("expect %v, got %v", ErrInvalidCABundle, err)
+		}
+	})
+}
+
+func Test_retryTransport(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures int
+		status   int
+		attempts int
+		expect   int
+		requests int
+	}{
+		{name: "success after failures", failures: 2, status: http.StatusServiceUnavailable, attempts: 3,
+			expect: http.StatusOK, requests: 3},
+		{name: "too many failures", failures: 2, status: http.StatusTooManyRequests, attempts: 2,
+			expect: http.StatusTooManyRequests, requests: 2},
+		{name: "no retries", failures: 1, status: http.StatusBadGateway, attempts: 0,
+			expect: http.StatusBadGateway, requests: 1},
+		{name: "permanent failure", failures: 1, status: http.StatusNotFound, attempts: 3,
+			expect: http.StatusNotFound, requests: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				requests++
+				if body, err := io.ReadAll(req.Body); err != nil || string(body) != "paths=a" {
+					t.Errorf("expect the body is sent again, got %q (%v)", body, err)
+				}
+				if requests <= c.failures {
+					res.Header().Set("Retry-After", "0")
+					res.WriteHeader(c.status)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			cli := &http.Client{Transport: retryTransport{
+				attempts: c.attempts,
+				wait:     time.Millisecond,
+				maxWait:  time.Millisecond,
+				base:     http.DefaultTransport,
+			}}
+			res, err := cli.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("paths=a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = res.Body.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != c.expect || requests != c.requests {
+				t.Errorf("expect %v after %v requests, got %v after %v requests",
+					c.expect, c.requests, res.StatusCode, requests)
+			}
+		})
+	}
+}
+
+func Test_retryTransport_backoff(t *testing.T) {
+	tr := retryTransport{wait: time.Second, maxWait: 10 * time.Second}
+	retryAfter := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {v}}}
+	}
+
+	if res := tr.backoff(1, retryAfter("30")); res != 30*time.Second {
+		t.Errorf("expect 30s, got %v", res)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if res := tr.backoff(1, retryAfter(date)); res < 58*time.Second || res > time.Minute {
+		t.Errorf("expect about 1m, got %v", res)
+	}
+
+	for attempt, expect := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second,
+		5: 10 * time.Second, 100: 10 * time.Second} {
+		if res := tr.backoff(attempt, nil); res < expect/2 || res > expect {
+			t.Errorf("expect a wait between %v and %v for attempt %v, got %v", expect/2, expect, attempt, res)
+		}
+	}
+}
+
+func Test_rateLimitTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	// a burst of 2 requests and then a request every 50ms.
+	cli := &http.Client{Transport: rateLimitTransport{
+		limiter: rate.NewLimiter(rate.Every(50*time.Millisecond), 2),
+		base:    http.DefaultTransport,
+	}}
+	start := time.Now()
+	for range 4 {
+		res, err := cli.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = res.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expect requests are limited, but they took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect %v, got %v", context.Canceled, err)
+	}
+}
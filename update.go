@@ -13,12 +13,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
@@ -26,12 +27,25 @@ import (
 	"github.com/fatih/color"
 	"github.com/jkawamoto/go-civitai/models"
 	"github.com/zeebo/blake3"
+	"golang.org/x/sync/errgroup"
 )
 
 const pbTemplate = `{{with string . "prefix"}}{{.}} {{end}}{{bar . }} {{percent . }}{{with string . "suffix"}} {{.}}{{end}}`
 
 // fileHash returns the BLAKE3 hash of the given named file.
-func fileHash(name string) (_ string, err error) {
+func fileHash(name string) (string, error) {
+	return fileHashWithBar(name, nil)
+}
+
+// fileHashWithBar returns the BLAKE3 hash of the given named file and shows the progress in the given bar.
+// If the bar is nil, it starts a new progress bar.
+func fileHashWithBar(name string, bar *pb.ProgressBar) (string, error) {
+	return hashFileWithBar(name, blake3.New(), bar)
+}
+
+// hashFileWithBar returns the hash of the given named file computed by the given hash function, and shows the
+// progress in the given bar. If the bar is nil, it starts a new progress bar.
+func hashFileWithBar(name string, hash hash.Hash, bar *pb.ProgressBar) (_ string, err error) {
 	f, err := os.Open(name)
 	if err != nil {
 		return "", err
@@ -45,14 +59,18 @@ func fileHash(name string) (_ string, err error) {
 		return "", err
 	}
 
-	bar := pb.New(int(info.Size()))
-	bar.SetTemplate(pbTemplate)
-	bar.Set(pb.SIBytesPrefix, true)
+	if bar == nil {
+		bar = pb.New64(info.Size())
+		bar.SetTemplate(pbTemplate)
+		bar.Set(pb.SIBytesPrefix, true)
+		bar.Start()
+		defer bar.Finish()
+	} else {
+		bar.SetTotal(info.Size())
+		bar.SetCurrent(0)
+	}
 	bar.Set("prefix", filepath.Base(name)+" ")
-	bar.Start()
-	defer bar.Finish()
 
-	hash := blake3.New()
 	_, err = io.Copy(hash, bar.NewProxyReader(f))
 	if err != nil {
 		return "", err
@@ -61,42 +79,121 @@ func fileHash(name string) (_ string, err error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// LocalFile is a model file found in the local storage.
+type LocalFile struct {
+	Path string
+	// Hash is the hash used to look up the model, which is either BLAKE3 or SHA256.
+	Hash string
+	// Version is the model version of this file. It is nil if the model is unknown.
+	Version *models.ModelVersion
+	// Err is the error that occurred while looking up the model of this file.
+	// It is nil if the model is found or no sources know it.
+	Err error
+}
+
+// printUnknown prints a message about the given file whose model is not identified.
+func printUnknown(f LocalFile) {
+	if f.Err != nil {
+		fmt.Println(color.RedString("Failed to look up %v: %v", filepath.Base(f.Path), f.Err))
+		return
+	}
+	fmt.Println(color.YellowString("Model information is not found: %v", filepath.Base(f.Path)))
+}
+
 // Update packs information about new versions for a model.
 type Update struct {
-	ModelName      string
+	ModelID   int64
+	ModelName string
+	// ModelType is the type of the model on Civitai such as Checkpoint and LORA.
+	ModelType string
+	// CurrentVersion is the name of the newest local version. It is empty if the model is being installed.
 	CurrentVersion string
-	Candidates     map[string]*models.ModelVersion
+	// BaseModel is the base model of the current version such as SD 1.5 and SDXL 1.0.
+	BaseModel string
+	// Candidates is a list of versions to download sorted newest first.
+	Candidates modelVersionList
+	// Incompatible is the number of newer versions excluded from the candidates because their base models differ.
+	Incompatible int
+	// Files is a list of local files that belong to this model.
+	Files []LocalFile
+	// Source is the source hosting the model. If it is nil, the model is downloaded from Civitai.
+	Source Source
 }
 
-// findUpdate retrieves the model information of the given model file.
-func findUpdate(ctx context.Context, cli Client, name string) (*Update, error) {
-	hash, err := fileHash(name)
+// findUpdate retrieves the model information of the given model file from Civitai and the other sources
+// in the options. Exclude and Jobs in the options are ignored.
+func findUpdate(ctx context.Context, cli Client, hashes *hashCache, name string, opts scanOptions) (*Update, error) {
+	hash, err := hashes.lookupHash(name, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	cur, err := cli.GetModelVersion(ctx, hash)
+	sources := opts.sources(cli)
+	i, m, cur, err := lookupHash(ctx, sources, name, hash)
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := cli.GetModel(ctx, cur.ID)
-	if err != nil {
-		return nil, err
-	}
+	return fetchUpdate(ctx, sources[i], m, []LocalFile{{Path: name, Hash: hash, Version: cur}}, opts.AnyBaseModel)
+}
 
+// newUpdate creates an update of the given model from the given versions of the model and the given local files
+// whose versions are known. The newest local version is the current version, and versions already present locally
+// are excluded from the candidates.
+func newUpdate(m *models.Model, versions []*models.ModelVersion, files []LocalFile, anyBaseModel bool) *Update {
 	res := &Update{
-		ModelName:      m.Name,
-		CurrentVersion: cur.Name,
-		Candidates:     make(map[string]*models.ModelVersion),
+		ModelID:   m.ID,
+		ModelName: m.Name,
+		ModelType: string(m.Type),
+		Files:     files,
 	}
-	for _, v := range m.ModelVersions {
-		if time.Time(v.PublishedAt).After(time.Time(cur.PublishedAt)) {
-			res.Candidates[v.Name] = v
+	cur := res.current().Version
+	res.CurrentVersion = cur.Name
+	res.BaseModel = cur.BaseModel
+
+	candidates, incompatible := newerVersions(versions, cur, anyBaseModel)
+	for _, v := range candidates {
+		if !res.hasVersion(v) {
+			res.Candidates = append(res.Candidates, v)
 		}
 	}
+	res.Incompatible = incompatible
+	return res
+}
 
-	return res, nil
+// newerVersions returns versions published after the given current version sorted newest first.
+// Unless anyBaseModel is true, it excludes versions whose base models differ from the current one's,
+// and returns the number of the excluded versions.
+func newerVersions(
+	versions []*models.ModelVersion, cur *models.ModelVersion, anyBaseModel bool,
+) (modelVersionList, int) {
+	var res modelVersionList
+	excluded := 0
+	for _, v := range versions {
+		if !time.Time(v.PublishedAt).After(time.Time(cur.PublishedAt)) {
+			continue
+		}
+		if !anyBaseModel && cur.BaseModel != "" && !strings.EqualFold(v.BaseModel, cur.BaseModel) {
+			excluded++
+			continue
+		}
+		res = append(res, v)
+	}
+	sort.Stable(sort.Reverse(res))
+	return res, excluded
+}
+
+// findVersionFile returns the version and its file that have the given hash, which is either BLAKE3 or SHA256.
+// It returns nil if no files have the hash.
+func findVersionFile(versions []*models.ModelVersion, hash string) (*models.ModelVersion, *models.File) {
+	for _, v := range versions {
+		for _, f := range v.Files {
+			if f.Hashes != nil && (strings.EqualFold(f.Hashes.BLAKE3, hash) || strings.EqualFold(f.Hashes.SHA256, hash)) {
+				return v, f
+			}
+		}
+	}
+	return nil, nil
 }
 
 // modelVersionList is an alias of []*models.ModelVersion that implements sort.Interface.
@@ -114,9 +211,76 @@ func (m modelVersionList) Swap(i, j int) {
 	m[i], m[j] = m[j], m[i]
 }
 
-func findUpdatesFromDir(ctx context.Context, cli Client, dir string) ([]*Update, error) {
-	ms := make(map[int64]modelVersionList)
+// newHashBars creates progress bars for the given number of workers hashing files and a bar counting processed files.
+// The bars are shown in a pool if the terminal supports it; the returned function stops the pool.
+func newHashBars(workers, files int) ([]*pb.ProgressBar, *pb.ProgressBar, func()) {
+	bars := make([]*pb.ProgressBar, workers)
+	for i := range bars {
+		bars[i] = pb.New(0)
+		bars[i].SetTemplate(pbTemplate)
+		bars[i].Set(pb.SIBytesPrefix, true)
+	}
+	total := pb.New(files)
+	total.Set("prefix", "Files ")
 
+	pool := pb.NewPool(append([]*pb.ProgressBar{total}, bars...)...)
+	if err := pool.Start(); err != nil {
+		// bars in a pool are static, so nothing is shown.
+		return bars, total, func() {}
+	}
+	return bars, total, func() {
+		for _, bar := range bars {
+			bar.Set("prefix", "")
+			bar.Finish()
+		}
+		total.Finish()
+		_ = pool.Stop()
+	}
+}
+
+// hashBarKey is the context key of the progress bar of the worker looking up a file.
+type hashBarKey struct{}
+
+// withHashBar returns a copy of the given context carrying the given progress bar, so that sources hashing the file
+// they look up show the progress in the bar of the worker instead of starting another one.
+func withHashBar(ctx context.Context, bar *pb.ProgressBar) context.Context {
+	return context.WithValue(ctx, hashBarKey{}, bar)
+}
+
+// hashBar returns the progress bar the given context carries, or nil if it has none.
+func hashBar(ctx context.Context) *pb.ProgressBar {
+	bar, _ := ctx.Value(hashBarKey{}).(*pb.ProgressBar)
+	return bar
+}
+
+// scanOptions configures findUpdate and findUpdatesFromDir.
+type scanOptions struct {
+	// Jobs is the number of workers hashing files and looking up models concurrently.
+	Jobs int
+	// Exclude is a list of patterns of files and directories to skip.
+	Exclude []string
+	// AnyBaseModel offers newer versions even if their base models differ from the current version's.
+	AnyBaseModel bool
+	// Sources is a list of sources asked in order about files Civitai doesn't know.
+	Sources []Source
+}
+
+// sources returns the given client followed by the sources in the options.
+func (opts scanOptions) sources(cli Client) []Source {
+	return append([]Source{cli}, opts.Sources...)
+}
+
+// findUpdatesFromDir retrieves the model information of model files in the given directory from Civitai and
+// the other sources in the options.
+// It returns updates of all identified models, even if they have no newer versions,
+// and files whose models are not identified. If looking up a file fails, the file has the error and
+// the other files are still checked, so that a temporary failure doesn't abort the whole scan.
+func findUpdatesFromDir(
+	ctx context.Context, cli Client, hashes *hashCache, dir string, opts scanOptions,
+) ([]*Update, []LocalFile, error) {
+	jobs := max(opts.Jobs, 1)
+
+	var paths []string
 	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -124,122 +288,474 @@ func findUpdatesFromDir(ctx context.Context, cli Client, dir string) ([]*Update,
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		if !isModelFile(path) {
+		if path != dir && isExcluded(dir, path, opts.Exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-
-		hash, err := fileHash(path)
-		if err != nil {
-			return err
+		if isModelFile(path) {
+			paths = append(paths, path)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		v, err := cli.GetModelVersion(ctx, hash)
-		if err != nil {
-			if coder, ok := err.(interface {
-				Code() int
-			}); ok {
-				if coder.Code() == http.StatusNotFound {
-					fmt.Println(color.YellowString("Model information is not found"))
-					return nil
-				}
+	sources := opts.sources(cli)
+	files := make([]LocalFile, len(paths))
+	// found has the source that knows each file and the model of the file.
+	found := make([]struct {
+		source int
+		model  *models.Model
+	}, len(paths))
+	bars, total, stop := newHashBars(min(jobs, len(paths)), len(paths))
+	g, gctx := errgroup.WithContext(ctx)
+
+	ch := make(chan int)
+	g.Go(func() error {
+		defer close(ch)
+		for i := range paths {
+			select {
+			case ch <- i:
+			case <-gctx.Done():
+				return gctx.Err()
 			}
-			return err
 		}
-
-		ms[v.ID] = append(ms[v.ID], v)
 		return nil
 	})
+	for _, bar := range bars {
+		g.Go(func() error {
+			for i := range ch {
+				hash, err := hashes.lookupHash(paths[i], bar)
+				if err == nil {
+					found[i].source, found[i].model, files[i].Version, err =
+						lookupHash(withHashBar(gctx, bar), sources, paths[i], hash)
+				}
+				if gctx.Err() != nil {
+					return gctx.Err()
+				}
+
+				files[i].Path, files[i].Hash = paths[i], hash
+				if err != nil && !isNotFound(err) {
+					files[i].Err = err
+				}
+				total.Increment()
+			}
+			return nil
+		})
+	}
+	err = g.Wait()
+	stop()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// models are identified by their sources and their IDs or names.
+	type modelKey struct {
+		source int
+		id     int64
+		name   string
+	}
+	var keys []modelKey
+	var unknowns []LocalFile
+	ms := make(map[modelKey][]LocalFile)
+	for i, f := range files {
+		if f.Version == nil {
+			unknowns = append(unknowns, f)
+			continue
+		}
+		key := modelKey{source: found[i].source, id: found[i].model.ID, name: found[i].model.Name}
+		if _, ok := ms[key]; !ok {
+			keys = append(keys, key)
+		}
+		ms[key] = append(ms[key], f)
+	}
+
+	updates := make([]*Update, len(keys))
+	errs := make([]error, len(keys))
+	g, gctx = errgroup.WithContext(ctx)
+	g.SetLimit(jobs)
+	for i, key := range keys {
+		g.Go(func() error {
+			m := &models.Model{ID: key.id, Name: key.name}
+			updates[i], errs[i] = fetchUpdate(gctx, sources[key.source], m, ms[key], opts.AnyBaseModel)
+			return gctx.Err()
+		})
+	}
+	if err = g.Wait(); err != nil {
+		return nil, nil, err
 	}
 
 	var res []*Update
-	for modelID, versions := range ms {
-		model, err := cli.GetModel(ctx, modelID)
-		if err != nil {
-			return nil, err
+	for i, u := range updates {
+		if errs[i] == nil {
+			res = append(res, u)
+			continue
 		}
+		for _, f := range ms[keys[i]] {
+			if !isNotFound(errs[i]) {
+				f.Err = errs[i]
+			}
+			unknowns = append(unknowns, f)
+		}
+	}
+	return res, unknowns, nil
+}
+
+// Policy decides which newer versions to download.
+type Policy string
+
+const (
+	// PolicyAsk asks the user which versions to download.
+	PolicyAsk Policy = "ask"
+	// PolicyLatest downloads only the newest version.
+	PolicyLatest Policy = "latest"
+	// PolicyAll downloads all newer versions.
+	PolicyAll Policy = "all"
+	// PolicyNone reports newer versions without downloading them.
+	PolicyNone Policy = "none"
+)
 
-		sort.Sort(sort.Reverse(versions))
-		cur := versions[0]
+// OldVersionMode decides what to do with local files of old versions after newer versions are downloaded.
+type OldVersionMode string
+
+const (
+	// OldVersionKeep keeps old versions as they are.
+	OldVersionKeep OldVersionMode = "keep"
+	// OldVersionDelete deletes old versions.
+	OldVersionDelete OldVersionMode = "delete"
+	// OldVersionArchive moves old versions to an archive directory.
+	OldVersionArchive OldVersionMode = "archive"
+)
+
+// updateOptions configures Update.run.
+type updateOptions struct {
+	Policy Policy
+	Old    OldVersionMode
+	// ArchiveDir is the directory old versions are moved into.
+	ArchiveDir string
+	// Root is the root directory of the web UI. Paths in ArchiveDir are relative to it.
+	Root string
+	// Layout decides directories to store models of each type.
+	Layout Layout
+}
+
+// destination returns the directory to store new versions of a model of the given type
+// whose old versions are stored in the given directory.
+//
+// If the directory is in the web UI but not in the directory the layout expects for the model type,
+// it returns the expected one. Otherwise, it returns the given directory so that models organized in
+// subdirectories and models outside of the web UI stay where they are.
+func (opts updateOptions) destination(modelType, dir string) string {
+	d, ok := opts.Layout.modelDir(modelType)
+	if !ok {
+		return dir
+	}
+	typeDir := filepath.Join(opts.Root, d)
+	if rel, err := filepath.Rel(typeDir, dir); err == nil && filepath.IsLocal(rel) {
+		return dir
+	}
+	if rel, err := filepath.Rel(opts.Root, dir); err != nil || !filepath.IsLocal(rel) {
+		return dir
+	}
+	return typeDir
+}
+
+// retire deletes or archives the given files of old versions and their sidecar files according to the options.
+func (opts updateOptions) retire(files []LocalFile) error {
+	for _, f := range files {
+		for _, name := range append([]string{f.Path}, sidecars(f.Path)...) {
+			switch opts.Old {
+			case OldVersionDelete:
+				if err := os.Remove(name); err != nil {
+					return err
+				}
+				fmt.Println(color.YellowString("Deleted %v", filepath.Base(name)))
 
-		sort.Sort(sort.Reverse(modelVersionList(model.ModelVersions)))
-		candidates := make(map[string]*models.ModelVersion)
-		for _, v := range model.ModelVersions {
-			if time.Time(v.PublishedAt).After(time.Time(cur.PublishedAt)) {
-				candidates[v.Name] = v
+			case OldVersionArchive:
+				rel, err := filepath.Rel(opts.Root, name)
+				if err != nil || !filepath.IsLocal(rel) {
+					rel = filepath.Base(name)
+				}
+				dest := filepath.Join(opts.ArchiveDir, rel)
+				if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+					return err
+				}
+				if err = moveFile(name, dest); err != nil {
+					return err
+				}
+				fmt.Println(color.YellowString("Archived %v to %v", filepath.Base(name), dest))
+			}
+		}
+	}
+	return nil
+}
+
+// moveFile moves the file src to dest. It doesn't overwrite an existing file.
+func moveFile(src, dest string) (err error) {
+	if _, err = os.Stat(dest); err == nil {
+		return fmt.Errorf("%v already exists: %w", dest, os.ErrExist)
+	}
+	if os.Rename(src, dest) == nil {
+		return nil
+	}
+
+	// rename doesn't work across file systems, so copy the file instead.
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, r.Close())
+		if err == nil {
+			err = os.Remove(src)
+		}
+	}()
+
+	w, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err == nil {
+		err = w.Sync()
+	}
+	if err = errors.Join(err, w.Close()); err != nil {
+		return errors.Join(err, os.Remove(dest))
+	}
+	return nil
+}
+
+// latest returns the newest candidate, or nil if there are no candidates.
+func (u Update) latest() *models.ModelVersion {
+	if len(u.Candidates) == 0 {
+		return nil
+	}
+	return u.Candidates[0]
+}
+
+// current returns the local file of the newest version, or nil if no versions are installed.
+func (u Update) current() *LocalFile {
+	var res *LocalFile
+	for i, f := range u.Files {
+		if f.Version == nil {
+			continue
+		}
+		if res == nil || time.Time(f.Version.PublishedAt).After(time.Time(res.Version.PublishedAt)) {
+			res = &u.Files[i]
+		}
+	}
+	return res
+}
+
+// hasVersion returns true if any local files match files of the given version.
+// A local file also matches if the file of its version has the same hash as a file of the given version, such as
+// a Hugging Face file whose content is unchanged in a newer commit.
+func (u Update) hasVersion(ver *models.ModelVersion) bool {
+	for _, f := range u.Files {
+		if v, _ := findVersionFile([]*models.ModelVersion{ver}, f.Hash); v != nil {
+			return true
+		}
+		if f.Version == nil {
+			continue
+		}
+		_, local := findVersionFile([]*models.ModelVersion{f.Version}, f.Hash)
+		for _, file := range ver.Files {
+			if sameFile(local, file) {
+				return true
 			}
 		}
+	}
+	return false
+}
+
+// sameFile returns true if the given files have the same BLAKE3 or SHA256 hash.
+func sameFile(a, b *models.File) bool {
+	if a == nil || b == nil || a.Hashes == nil || b.Hashes == nil {
+		return false
+	}
+	return a.Hashes.BLAKE3 != "" && strings.EqualFold(a.Hashes.BLAKE3, b.Hashes.BLAKE3) ||
+		a.Hashes.SHA256 != "" && strings.EqualFold(a.Hashes.SHA256, b.Hashes.SHA256)
+}
 
-		if len(candidates) != 0 {
-			res = append(res, &Update{
-				ModelName:      model.Name,
-				CurrentVersion: cur.Name,
-				Candidates:     candidates,
-			})
+// model returns the model of the update with the fields the update has.
+func (u Update) model() *models.Model {
+	return &models.Model{ID: u.ModelID, Name: u.ModelName, Type: u.ModelType}
+}
+
+// userMetadata returns the path to the user metadata of the current version, or an empty string if it doesn't exist.
+func (u Update) userMetadata() string {
+	if f := u.current(); f != nil {
+		name := sidecarPath(f.Path, userMetadataExt)
+		if _, err := os.Stat(name); err == nil {
+			return name
 		}
 	}
+	return ""
+}
 
-	return res, nil
+// installing returns true if no versions of the model are installed.
+func (u Update) installing() bool {
+	return u.CurrentVersion == ""
 }
 
-func (u Update) run(ctx context.Context, cli Client, dest string) error {
+// run downloads newer versions chosen according to the policy next to the newest local version,
+// or into the given directory if no versions are installed, and then retires the old versions.
+func (u Update) run(ctx context.Context, cli Client, dir string, opts updateOptions) error {
+	if f := u.current(); f != nil {
+		dir = filepath.Dir(f.Path)
+	}
+	dest := opts.destination(u.ModelType, dir)
+	var src Source = cli
+	if u.Source != nil {
+		src = u.Source
+	}
+	var downloaded []*models.ModelVersion
+	download := func(ver *models.ModelVersion) error {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		name, err := src.Download(ctx, ver, dest)
+		if err != nil {
+			return err
+		}
+		downloaded = append(downloaded, ver)
+		if u.Source == nil {
+			// the model has been downloaded even if its metadata cannot be written.
+			if err = cli.WriteMetadata(ctx, u.model(), ver, name); err != nil {
+				fmt.Println(color.YellowString("Failed to write metadata of %v: %v", filepath.Base(name), err))
+			}
+		}
+		if isLoRA(u.ModelType) {
+			// the model has been downloaded even if its metadata cannot be written.
+			if err = writeUserMetadata(name, ver, u.userMetadata()); err != nil {
+				fmt.Println(color.YellowString("Failed to write the user metadata of %v: %v", filepath.Base(name), err))
+			}
+		}
+		return nil
+	}
+
 	switch len(u.Candidates) {
 	case 0:
+		if u.Incompatible != 0 {
+			fmt.Println(u.ModelName, "has no updates for", u.BaseModel,
+				color.YellowString("(%v newer versions for other base models are skipped; give -any-base-model to include them)",
+					u.Incompatible))
+			return nil
+		}
 		fmt.Println(u.ModelName, "has no updates")
 		return nil
 
 	case 1:
-		fmt.Println(color.GreenString("%v has a newer version", u.ModelName))
-		var ver *models.ModelVersion
-		for _, v := range u.Candidates {
-			ver = v
+		ver := u.latest()
+		label := versionLabel(ver, cli.selectFile(ver))
+		message := fmt.Sprintf("Do you want to update %v \u279c %v", u.CurrentVersion, label)
+		if u.installing() {
+			message = fmt.Sprintf("Do you want to install %v %v", u.ModelName, label)
+		} else {
+			fmt.Println(color.GreenString("%v has a newer version", u.ModelName))
 		}
 
-		var confirm bool
-		err := survey.AskOne(&survey.Confirm{
-			Message: fmt.Sprintf("Do you want to update %v \u279c %v", u.CurrentVersion, ver.Name),
-		}, &confirm)
-		if err != nil {
-			return err
+		confirm := opts.Policy == PolicyLatest || opts.Policy == PolicyAll
+		if opts.Policy == PolicyAsk {
+			err := survey.AskOne(&survey.Confirm{
+				Message: message,
+				Help:    versionHelp([]*models.ModelVersion{ver}),
+			}, &confirm)
+			if err != nil {
+				return err
+			}
 		}
 		if !confirm {
-			fmt.Println(color.YellowString("Skipped downloading the newer model"))
+			if u.installing() {
+				fmt.Println(color.YellowString("Skipped installing %v", u.ModelName))
+			} else {
+				fmt.Println(color.YellowString("Skipped downloading the newer model"))
+			}
 			return nil
 		}
 
-		if err = cli.Download(ctx, ver, dest); err != nil {
+		if err := download(ver); err != nil {
 			return err
 		}
 
 	default:
-		fmt.Println(color.GreenString("%v has multiple newer versions", u.ModelName))
-
-		var opts []string
-		for n := range u.Candidates {
-			opts = append(opts, n)
+		message := "Which versions do you want to download"
+		if u.installing() {
+			fmt.Println(color.GreenString("%v has multiple versions", u.ModelName))
+		} else {
+			fmt.Println(color.GreenString("%v has multiple newer versions", u.ModelName))
+			message += fmt.Sprintf(" (current: %v)", u.CurrentVersion)
 		}
 
-		var selected []string
-		err := survey.AskOne(&survey.MultiSelect{
-			Message: fmt.Sprintf("Which versions do you want to download (current: %v)", u.CurrentVersion),
-			Options: opts,
-		}, &selected)
-		if err != nil {
-			return err
+		var selected []*models.ModelVersion
+		switch opts.Policy {
+		case PolicyAsk:
+			names := make([]string, len(u.Candidates))
+			for i, v := range u.Candidates {
+				names[i] = v.Name
+			}
+
+			// versions may have the same name, so answers are identified by their indexes.
+			var answers []int
+			err := survey.AskOne(&survey.MultiSelect{
+				Message: message,
+				Options: names,
+				Default: []int{0},
+				Description: func(_ string, i int) string {
+					return versionDetails(u.Candidates[i], cli.selectFile(u.Candidates[i]))
+				},
+				Help: versionHelp(u.Candidates),
+			}, &answers)
+			if err != nil {
+				return err
+			}
+			for _, i := range answers {
+				selected = append(selected, u.Candidates[i])
+			}
+
+		case PolicyLatest:
+			selected = append(selected, u.latest())
+
+		case PolicyAll:
+			selected = u.Candidates
 		}
 		if len(selected) == 0 {
-			fmt.Println(color.YellowString("Skipped downloading any models"))
+			if u.installing() {
+				fmt.Println(color.YellowString("Skipped installing %v", u.ModelName))
+			} else {
+				fmt.Println(color.YellowString("Skipped downloading any models"))
+			}
 			return nil
 		}
 
-		for _, n := range selected {
-			ver := u.Candidates[n]
-			if err = cli.Download(ctx, ver, dest); err != nil {
+		for _, ver := range selected {
+			if err := download(ver); err != nil {
 				return err
 			}
 		}
 	}
 
-	return nil
+	// all downloaded files have been verified, so the old versions they replace are no longer necessary.
+	return opts.retire(u.replaced(downloaded))
+}
+
+// replaced returns local files replaced by the given downloaded versions. Files of versions for other base models
+// are kept since they are still the latest for their base models.
+func (u Update) replaced(downloaded []*models.ModelVersion) []LocalFile {
+	var res []LocalFile
+	for _, f := range u.Files {
+		if f.Version == nil {
+			res = append(res, f)
+			continue
+		}
+		for _, v := range downloaded {
+			if strings.EqualFold(f.Version.BaseModel, v.BaseModel) {
+				res = append(res, f)
+				break
+			}
+		}
+	}
+	return res
 }
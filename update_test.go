@@ -9,14 +9,21 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/go-openapi/strfmt"
+	"github.com/jkawamoto/go-civitai/models"
 	"github.com/zeebo/blake3"
 )
 
@@ -109,3 +116,411 @@ func Test_modelVersionList(t *testing.T) {
 		}
 	})
 }
+
+// newVersionServer starts a server that serves a model file for each of the given version names
+// and returns model versions pointing to the files. Versions are published in the given order.
+func newVersionServer(t *testing.T, names ...string) (*httptest.Server, map[string]*models.ModelVersion) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	res := make(map[string]*models.ModelVersion)
+	for i, n := range names {
+		filename := n + ".safetensors"
+		hash := blake3.Sum256([]byte(n))
+		mux.HandleFunc("/"+n, func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v;", filename))
+			res.WriteHeader(http.StatusOK)
+			if _, err := res.Write([]byte(n)); err != nil {
+				t.Error(err)
+			}
+		})
+		res[n] = &models.ModelVersion{
+			ID:          int64(i + 1),
+			Name:        n,
+			PublishedAt: strfmt.DateTime(time.Now().Add(time.Duration(i) * time.Hour)),
+			Files: []*models.File{
+				{
+					Name:        filename,
+					DownloadURL: joinURL(t, server.URL, n),
+					Format:      "SafeTensor",
+					Primary:     true,
+					Hashes: &models.Hash{
+						BLAKE3: hex.EncodeToString(hash[:]),
+					},
+				},
+			},
+		}
+	}
+
+	return server, res
+}
+
+// newestFirst returns the given versions sorted newest first.
+func newestFirst(versions map[string]*models.ModelVersion) modelVersionList {
+	res := make(modelVersionList, 0, len(versions))
+	for _, v := range versions {
+		res = append(res, v)
+	}
+	sort.Sort(sort.Reverse(res))
+	return res
+}
+
+func TestUpdate_run(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name       string
+		candidates []string
+		policy     Policy
+		expect     []string
+	}{
+		{name: "none, single candidate", candidates: []string{"v2"}, policy: PolicyNone},
+		{name: "latest, single candidate", candidates: []string{"v2"}, policy: PolicyLatest, expect: []string{"v2"}},
+		{name: "all, single candidate", candidates: []string{"v2"}, policy: PolicyAll, expect: []string{"v2"}},
+		{name: "none, multiple candidates", candidates: []string{"v2", "v3"}, policy: PolicyNone},
+		{name: "latest, multiple candidates", candidates: []string{"v2", "v3"}, policy: PolicyLatest, expect: []string{"v3"}},
+		{name: "all, multiple candidates", candidates: []string{"v2", "v3"}, policy: PolicyAll, expect: []string{"v2", "v3"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, versions := newVersionServer(t, c.candidates...)
+			cli := NewClient(SafetensorFormat)
+			cli.httpClient = server.Client()
+
+			dir := t.TempDir()
+			u := Update{
+				ModelName:      "model",
+				CurrentVersion: "v1",
+				Candidates:     newestFirst(versions),
+			}
+			if err := u.run(ctx, cli, dir, updateOptions{Policy: c.policy, Old: OldVersionKeep}); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			for _, e := range entries {
+				if isModelFile(e.Name()) {
+					n++
+				}
+			}
+			if n != len(c.expect) {
+				t.Errorf("expect %v files, got %v", len(c.expect), n)
+			}
+			for _, n := range c.expect {
+				if _, err = os.Stat(filepath.Join(dir, n+".safetensors")); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_findUpdatesFromDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	version := func(id int64, name string, published time.Duration) *models.ModelVersion {
+		hash := blake3.Sum256([]byte(name))
+		return &models.ModelVersion{
+			ID:          id,
+			Name:        name,
+			PublishedAt: strfmt.DateTime(time.Now().Add(published)),
+			Files: []*models.File{
+				{
+					Name:    name + ".safetensors",
+					Primary: true,
+					Hashes:  &models.Hash{BLAKE3: hex.EncodeToString(hash[:])},
+				},
+			},
+		}
+	}
+	// each model ID is also a version ID of the other model, so that confusing them finds the wrong model.
+	service := &fakeService{
+		models: []*models.Model{
+			{
+				ID:   21,
+				Name: "model-a",
+				ModelVersions: []*models.ModelVersion{
+					version(11, "a-v1", 0), version(12, "a-v2", time.Hour), version(13, "a-v3", 2*time.Hour),
+				},
+			},
+			{
+				ID:            11,
+				Name:          "model-b",
+				ModelVersions: []*models.ModelVersion{version(21, "b-v1", 0)},
+			},
+		},
+	}
+
+	for _, name := range []string{"a-v1", "a-v2", "b-v1", "unknown"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".safetensors"), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, jobs := range []int{0, 1, 4} {
+		t.Run(fmt.Sprintf("jobs: %v", jobs), func(t *testing.T) {
+			cli := service.newClient(t)
+
+			updates, unknowns, err := findUpdatesFromDir(ctx, cli, nil, dir, scanOptions{Jobs: jobs})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(unknowns) != 1 || filepath.Base(unknowns[0].Path) != "unknown.safetensors" {
+				t.Errorf("unexpected unknown files: %v", unknowns)
+			}
+
+			if len(updates) != 2 {
+				t.Fatalf("expect 2 updates, got %v", len(updates))
+			}
+			a, b := updates[0], updates[1]
+			if a.ModelID != 21 || a.ModelName != "model-a" || a.CurrentVersion != "a-v2" || len(a.Files) != 2 {
+				t.Errorf("unexpected update: %+v", a)
+			}
+			for _, f := range a.Files {
+				if f.Version == nil || f.Version.Name+".safetensors" != filepath.Base(f.Path) || f.Version.ID < 11 {
+					t.Errorf("expect the version of %v is identified, got %+v", f.Path, f.Version)
+				}
+			}
+			if len(a.Candidates) != 1 || a.Candidates[0].Name != "a-v3" {
+				t.Errorf("expect a-v3 is the only candidate, got %v", a.Candidates)
+			}
+			if b.ModelID != 11 || b.ModelName != "model-b" || b.CurrentVersion != "b-v1" || len(b.Candidates) != 0 {
+				t.Errorf("unexpected update: %+v", b)
+			}
+		})
+	}
+}
+
+func TestUpdate_run_old(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		old     OldVersionMode
+		policy  Policy
+		keep    bool
+		archive bool
+		// other adds a local file of a version for another base model, which must be kept.
+		other bool
+	}{
+		{name: "keep", old: OldVersionKeep, policy: PolicyLatest, keep: true},
+		{name: "delete", old: OldVersionDelete, policy: PolicyLatest},
+		{name: "archive", old: OldVersionArchive, policy: PolicyLatest, archive: true},
+		{name: "not downloaded", old: OldVersionDelete, policy: PolicyNone, keep: true},
+		{name: "other base model", old: OldVersionDelete, policy: PolicyLatest, other: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, versions := newVersionServer(t, "v2")
+			versions["v2"].BaseModel = "SDXL 1.0"
+			cli := NewClient(SafetensorFormat)
+			cli.httpClient = server.Client()
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "models", "Lora")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			oldFile := filepath.Join(dir, "v1.safetensors")
+			if err := os.WriteFile(oldFile, []byte("v1"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			oldInfo := sidecarPath(oldFile, civitaiInfoExt)
+			if err := os.WriteFile(oldInfo, []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			u := Update{
+				ModelName:      "model",
+				CurrentVersion: "v1",
+				Candidates:     newestFirst(versions),
+				Files: []LocalFile{{
+					Path:    oldFile,
+					Version: &models.ModelVersion{Name: "v1", BaseModel: "SDXL 1.0"},
+				}},
+			}
+			otherFile := filepath.Join(dir, "sd15.safetensors")
+			if c.other {
+				if err := os.WriteFile(otherFile, []byte("sd15"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				u.Files = append(u.Files, LocalFile{
+					Path:    otherFile,
+					Version: &models.ModelVersion{Name: "sd15", BaseModel: "SD 1.5"},
+				})
+			}
+			opts := updateOptions{
+				Policy:     c.policy,
+				Old:        c.old,
+				ArchiveDir: filepath.Join(root, "archive"),
+				Root:       root,
+			}
+			if err := u.run(ctx, cli, dir, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(oldFile); c.keep != (err == nil) {
+				t.Errorf("expect the old version is kept: %v, got %v", c.keep, err)
+			}
+			archived := filepath.Join(root, "archive", "models", "Lora", "v1.safetensors")
+			if _, err := os.Stat(archived); c.archive != (err == nil) {
+				t.Errorf("expect the old version is archived: %v, got %v", c.archive, err)
+			}
+			if _, err := os.Stat(oldInfo); c.keep != (err == nil) {
+				t.Errorf("expect the sidecar of the old version is kept: %v, got %v", c.keep, err)
+			}
+			if _, err := os.Stat(sidecarPath(archived, civitaiInfoExt)); c.archive != (err == nil) {
+				t.Errorf("expect the sidecar of the old version is archived: %v, got %v", c.archive, err)
+			}
+			if _, err := os.Stat(otherFile); c.other != (err == nil) {
+				t.Errorf("expect the version for another base model is kept: %v, got %v", c.other, err)
+			}
+		})
+	}
+}
+
+func Test_newUpdate(t *testing.T) {
+	ctx := context.Background()
+	server, versions := newVersionServer(t, "v1", "v2", "v3", "v4")
+	cli := NewClient(SafetensorFormat)
+	cli.httpClient = server.Client()
+
+	// v3 is a re-upload of the same file as v2.
+	versions["v3"].Files[0].Hashes = versions["v2"].Files[0].Hashes
+
+	root := t.TempDir()
+	var files []LocalFile
+	for _, name := range []string{"old/v1", "new/v2"} {
+		path := filepath.Join(root, name+".safetensors")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		ver := versions[filepath.Base(name)]
+		files = append(files, LocalFile{Path: path, Hash: ver.Files[0].Hashes.BLAKE3, Version: ver})
+	}
+
+	u := newUpdate(
+		&models.Model{Name: "model"},
+		[]*models.ModelVersion{versions["v1"], versions["v2"], versions["v3"], versions["v4"]},
+		files, false)
+	if u.CurrentVersion != "v2" || len(u.Files) != 2 {
+		t.Errorf("unexpected update: %+v", u)
+	}
+	if len(u.Candidates) != 1 || u.Candidates[0].Name != "v4" {
+		t.Errorf("expect v4 is the only candidate, got %v", u.Candidates)
+	}
+
+	if err := u.run(ctx, cli, root, updateOptions{Policy: PolicyLatest, Old: OldVersionKeep}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new", "v4.safetensors")); err != nil {
+		t.Errorf("expect the new version is downloaded next to the newest local version: %v", err)
+	}
+}
+
+func Test_updateOptions_destination(t *testing.T) {
+	root := "webui"
+	opts := updateOptions{
+		Root:   root,
+		Layout: LayoutComfyUI,
+	}
+
+	cases := []struct {
+		name      string
+		modelType string
+		dir       string
+		expect    string
+	}{
+		{
+			name:      "type directory",
+			modelType: ModelTypeLORA,
+			dir:       filepath.Join(root, "models", "loras"),
+			expect:    filepath.Join(root, "models", "loras"),
+		},
+		{
+			name:      "subdirectory of the type directory",
+			modelType: ModelTypeLORA,
+			dir:       filepath.Join(root, "models", "loras", "styles"),
+			expect:    filepath.Join(root, "models", "loras", "styles"),
+		},
+		{
+			name:      "another directory in the web UI",
+			modelType: ModelTypeLORA,
+			dir:       filepath.Join(root, "models", "checkpoints"),
+			expect:    filepath.Join(root, "models", "loras"),
+		},
+		{
+			name:      "outside of the web UI",
+			modelType: ModelTypeLORA,
+			dir:       filepath.Join("others", "loras"),
+			expect:    filepath.Join("others", "loras"),
+		},
+		{
+			name:      "unknown type",
+			modelType: "Wildcards",
+			dir:       filepath.Join(root, "models", "checkpoints"),
+			expect:    filepath.Join(root, "models", "checkpoints"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := opts.destination(c.modelType, c.dir); res != c.expect {
+				t.Errorf("expect %v, got %v", c.expect, res)
+			}
+		})
+	}
+}
+
+func Test_newerVersions(t *testing.T) {
+	now := time.Now()
+	version := func(name, baseModel string, published time.Duration) *models.ModelVersion {
+		return &models.ModelVersion{
+			Name:        name,
+			BaseModel:   baseModel,
+			PublishedAt: strfmt.DateTime(now.Add(published)),
+		}
+	}
+	versions := []*models.ModelVersion{
+		version("v1", "SD 1.5", 0),
+		version("v2", "SD 1.5", time.Hour),
+		version("v2-xl", "SDXL 1.0", time.Hour),
+		version("v3-pony", "Pony", 2*time.Hour),
+	}
+
+	cases := []struct {
+		name         string
+		cur          *models.ModelVersion
+		anyBaseModel bool
+		expect       []string
+		excluded     int
+	}{
+		{name: "same base model", cur: versions[0], expect: []string{"v2"}, excluded: 2},
+		{name: "any base model", cur: versions[0], anyBaseModel: true, expect: []string{"v3-pony", "v2", "v2-xl"}},
+		{name: "unknown base model", cur: version("v1", "", 0), expect: []string{"v3-pony", "v2", "v2-xl"}},
+		{name: "case insensitive", cur: version("v1", "sdxl 1.0", 0), expect: []string{"v2-xl"}, excluded: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, excluded := newerVersions(versions, c.cur, c.anyBaseModel)
+			var names []string
+			for _, v := range res {
+				names = append(names, v.Name)
+			}
+			if !reflect.DeepEqual(names, c.expect) || excluded != c.excluded {
+				t.Errorf("expect %v (%v excluded), got %v (%v excluded)", c.expect, c.excluded, names, excluded)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// webuicache.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// webUICacheFile is the name of the cache file the web UI stores in its root directory.
+const webUICacheFile = "cache.json"
+
+// webUICacheCategories maps categories of hashes in cache.json to model directories of the web UI.
+var webUICacheCategories = map[string][]string{
+	"checkpoint":        {filepath.Join("models", "Stable-diffusion")},
+	"lora":              {filepath.Join("models", "Lora"), filepath.Join("models", "LyCORIS")},
+	"textual_inversion": {"embeddings"},
+	"hypernet":          {filepath.Join("models", "hypernetworks")},
+}
+
+// webUICacheEntry is a SHA256 hash computed by the web UI and the modification time of the file at that time.
+type webUICacheEntry struct {
+	MTime  float64 `json:"mtime"`
+	SHA256 string  `json:"sha256"`
+}
+
+// webUICache provides SHA256 hashes stored in cache.json of AUTOMATIC1111's web UI.
+type webUICache struct {
+	root   string
+	hashes map[string]webUICacheEntry
+}
+
+// loadWebUICache reads the hashes section of the given cache.json.
+// The directory containing the file is considered as the root of the web UI.
+func loadWebUICache(name string) (*webUICache, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Hashes map[string]webUICacheEntry `json:"hashes"`
+	}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.Abs(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	return &webUICache{
+		root:   root,
+		hashes: raw.Hashes,
+	}, nil
+}
+
+// sha256 returns the SHA256 hash of the given named file if the web UI has a fresh hash for it.
+func (c *webUICache) sha256(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", false
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", false
+	}
+	mtime := float64(info.ModTime().UnixNano()) / 1e9
+
+	for category, dirs := range webUICacheCategories {
+		for _, dir := range dirs {
+			rel, err := filepath.Rel(filepath.Join(c.root, dir), abs)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+
+			// checkpoints are keyed by relative paths, and other models are keyed by base names without extensions.
+			rel = filepath.ToSlash(rel)
+			for _, key := range []string{
+				rel,
+				strings.TrimSuffix(rel, filepath.Ext(rel)),
+				strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)),
+			} {
+				e, ok := c.hashes[category+"/"+key]
+				if !ok || e.SHA256 == "" {
+					continue
+				}
+				// the web UI considers a hash is stale if the file is newer than it.
+				// a small margin absorbs rounding differences of floating point timestamps.
+				if mtime > e.MTime+1e-3 {
+					return "", false
+				}
+				return strings.ToLower(e.SHA256), true
+			}
+		}
+	}
+
+	return "", false
+}
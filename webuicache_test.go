@@ -0,0 +1,117 @@
+// webuicache_test.go
+//
+// Copyright (c) 2025 Junpei Kawamoto
+//
+// This software is released under the MIT License.
+//
+// http://opensource.org/licenses/mit-license.php
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_webUICache(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"checkpoint":       filepath.Join(root, "models", "Stable-diffusion", "sub", "model.safetensors"),
+		"lora":             filepath.Join(root, "models", "Lora", "sub", "lora.safetensors"),
+		"stale":            filepath.Join(root, "models", "Lora", "stale.safetensors"),
+		"missing":          filepath.Join(root, "models", "Lora", "missing.safetensors"),
+		"other category":   filepath.Join(root, "embeddings", "model.safetensors"),
+		"outside of webui": filepath.Join(root, "others", "lora.safetensors"),
+	}
+	for _, name := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mtime := func(name string) float64 {
+		t.Helper()
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return float64(info.ModTime().UnixNano()) / 1e9
+	}
+	data, err := json.Marshal(map[string]any{
+		"hashes": map[string]webUICacheEntry{
+			"checkpoint/sub/model.safetensors": {MTime: mtime(files["checkpoint"]), SHA256: "CHECKPOINT"},
+			"lora/lora":                        {MTime: mtime(files["lora"]), SHA256: "LORA"},
+			"lora/stale":                       {MTime: mtime(files["stale"]) - 10, SHA256: "STALE"},
+		},
+		"safetensors-metadata": map[string]any{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(root, webUICacheFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadWebUICache(filepath.Join(root, webUICacheFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		expect string
+	}{
+		{name: "checkpoint", expect: "checkpoint"},
+		{name: "lora", expect: "lora"},
+		{name: "stale"},
+		{name: "missing"},
+		{name: "other category"},
+		{name: "outside of webui"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, ok := c.sha256(files[tc.name])
+			if ok != (tc.expect != "") || res != tc.expect {
+				t.Errorf("expect %q, got %q (%v)", tc.expect, res, ok)
+			}
+		})
+	}
+
+	t.Run("modified after hashing", func(t *testing.T) {
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(files["lora"], future, future); err != nil {
+			t.Fatal(err)
+		}
+		if res, ok := c.sha256(files["lora"]); ok {
+			t.Errorf("expect no hash, got %v", res)
+		}
+	})
+
+	t.Run("lookupHash", func(t *testing.T) {
+		hashes := newHashCache("")
+		hashes.webUI = c
+
+		res, err := hashes.lookupHash(files["checkpoint"], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != "checkpoint" {
+			t.Errorf("expect checkpoint, got %v", res)
+		}
+
+		res, err = hashes.lookupHash(files["missing"], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := modelHash(t, files["missing"]); res != expect {
+			t.Errorf("expect %v, got %v", expect, res)
+		}
+	})
+}